@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/joho/godotenv"
+	"github.com/kelseyhightower/envconfig"
+	"go-url-short/internal/shorten"
+	"go-url-short/internal/store"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The conformance suite runs against every store that can be started locally:
+// the in-memory and SQLite stores always, PostgreSQL when DB_HOST is set and redis when REDIS_ADDR is set.
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("Error loading .env file")
+	}
+	var dbConfig store.DatabaseConfig
+	if err := envconfig.Process("DB", &dbConfig); err != nil {
+		log.Fatalln("Error processing database config: ", err)
+	}
+	var redisConfig store.RedisConfig
+	if err := envconfig.Process("REDIS", &redisConfig); err != nil {
+		log.Fatalln("Error processing redis config: ", err)
+	}
+	verbose := os.Getenv("CONFORMANCE_VERBOSE") != ""
+	if !verbose {
+		// The stores log every operation
+		log.SetOutput(io.Discard)
+	}
+
+	dir, err := os.MkdirTemp("", "conformance")
+	if err != nil {
+		fmt.Println("Error creating temporary directory: ", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+
+	stores := []struct {
+		name string
+		open func() store.Store
+	}{
+		{"inmem", func() store.Store {
+			return store.NewInMemStore(nil, keyGenerator(shorten.StrategyRandom))
+		}},
+		{"inmem persisted", func() store.Store {
+			return store.NewInMemStore(&store.InMemConfig{Dir: filepath.Join(dir, "inmem")}, keyGenerator(shorten.StrategyRandom))
+		}},
+		{"inmem sequential", func() store.Store {
+			return store.NewInMemStore(nil, keyGenerator(shorten.StrategySequential))
+		}},
+		{"sqlite", func() store.Store {
+			return store.NewSQLiteStore(&store.SQLiteConfig{Path: filepath.Join(dir, "conformance.db")}, keyGenerator(shorten.StrategySnowflake))
+		}},
+		{"inmem with lru cache", func() store.Store {
+			st := store.NewInMemStore(nil, keyGenerator(shorten.StrategyRandom))
+			return store.NewLRUCache(st, &store.CacheConfig{Size: 16, TTL: time.Minute, NegativeTTL: time.Minute})
+		}},
+		{"inmem with bloom filter", func() store.Store {
+			st := store.NewInMemStore(nil, keyGenerator(shorten.StrategyRandom))
+			return store.NewBloomFilter(st, &store.BloomConfig{Capacity: 1000, FalsePositiveRate: 0.01}, false)
+		}},
+	}
+	if dbConfig.Host != "" {
+		stores = append(stores, struct {
+			name string
+			open func() store.Store
+		}{"postgres", func() store.Store {
+			return store.NewPostgresStore(&dbConfig, keyGenerator(shorten.StrategySnowflake))
+		}})
+	}
+	if redisConfig.Addr != "" {
+		stores = append(stores, struct {
+			name string
+			open func() store.Store
+		}{"redis", func() store.Store {
+			st, err := store.NewRedisStore(&redisConfig, keyGenerator(shorten.StrategyRandom))
+			if err != nil {
+				panic(err)
+			}
+			return st
+		}})
+	}
+
+	failed := false
+	for _, s := range stores {
+		st := s.open()
+		err := store.CheckConformance(context.Background(), st)
+		st.DbClose(context.Background())
+		if err != nil {
+			failed = true
+			fmt.Printf("FAIL %s\n%v\n", s.name, err)
+			continue
+		}
+		fmt.Printf("ok   %s\n", s.name)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func keyGenerator(strategy string) shorten.KeyGenerator {
+	gen, err := shorten.NewKeyGenerator(&shorten.KeyGeneratorConfig{Strategy: strategy, Length: 6}, strategy)
+	if err != nil {
+		panic(err)
+	}
+	return gen
+}
@@ -1,14 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/awslabs/aws-lambda-go-api-proxy/core"
 	"github.com/awslabs/aws-lambda-go-api-proxy/gorillamux"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
 	"go-url-short/internal/server"
-	"go-url-short/internal/store"
 	"log"
 	"net/http"
 	"os"
@@ -21,25 +22,28 @@ func init() {
 func main() {
 	err := godotenv.Load()
 	if err != nil {
-		fmt.Errorf("Error loading .env file")
+		log.Println("Error loading .env file")
 	}
-	host, port := os.Getenv("HOST"), os.Getenv("PORT")
-	var dbConfig store.DatabaseConfig
-	if err := envconfig.Process("DB", &dbConfig); err != nil {
-		log.Fatalln("Error processing database config: ", err)
+	var args server.HTTPServerArgs
+	if err := envconfig.Process("", &args); err != nil {
+		log.Fatalln("Error processing server config: ", err)
 	}
-	s := server.NewHTTPServer(&server.HTTPServerArgs{
-		Host:     host,
-		Port:     port,
-		DbConfig: &dbConfig,
-	})
+	port := args.Port
+	s := server.NewHTTPServer(&args)
 
 	log.Println("Starting lambda server")
 	if runtime, _ := os.LookupEnv("AWS_EXECUTION_ENV"); runtime != "" {
 		log.Println("Running in AWS Lambda")
 		r := s.Handler.(*mux.Router)
 		adapter := gorillamux.New(r)
-		lambda.Start(adapter.ProxyWithContext)
+		lambda.Start(func(ctx context.Context, req core.SwitchableAPIGatewayRequest) (*core.SwitchableAPIGatewayResponse, error) {
+			resp, err := adapter.ProxyWithContext(ctx, req)
+			// Drain the buffered clicks before the execution environment is frozen
+			if err := s.Flush(ctx); err != nil {
+				log.Println("Error flushing clicks: ", err)
+			}
+			return resp, err
+		})
 	} else {
 		fmt.Printf("Running in Local :%s\n", port)
 		if err = s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const usage = `usage: go run ./cmd/jwks.go [flags]
+
+Serves the JWKS of a throwaway RSA key and prints bearer tokens signed with it,
+to try AUTH_JWT_JWKS without an identity provider:
+
+  AUTH_JWT_JWKS=http://localhost:8081/jwks.json go run ./cmd/main.go
+  curl -X POST localhost:8080/shorten -H "Authorization: Bearer $TOKEN" -d url=https://google.com
+
+flags:`
+
+const keyID = "local"
+
+func main() {
+	addr := flag.String("addr", "localhost:8081", "Address to serve the JWKS on")
+	subject := flag.String("sub", "local-user", "sub claim of the token")
+	owner := flag.String("owner", "", "Owner claim of the token, named by -owner-claim; defaults to the sub claim")
+	ownerClaim := flag.String("owner-claim", "sub", "Name of the owner claim, as AUTH_JWT_OWNERCLAIM")
+	scopes := flag.String("scopes", "create read-stats", "Space separated scopes of the token")
+	issuer := flag.String("iss", "", "iss claim of the token")
+	audience := flag.String("aud", "", "aud claim of the token")
+	ttl := flag.Duration("ttl", time.Hour, "Lifetime of the token")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalln("Error generating key: ", err)
+	}
+
+	claims := map[string]any{
+		"sub":   *subject,
+		"scope": *scopes,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(*ttl).Unix(),
+	}
+	if *owner != "" {
+		claims[*ownerClaim] = *owner
+	}
+	if *issuer != "" {
+		claims["iss"] = *issuer
+	}
+	if *audience != "" {
+		claims["aud"] = *audience
+	}
+	token, err := sign(key, claims)
+	if err != nil {
+		log.Fatalln("Error signing token: ", err)
+	}
+	fmt.Println(token)
+
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": keyID,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	if err != nil {
+		log.Fatalln("Error encoding JWKS: ", err)
+	}
+	http.HandleFunc("/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jwks)
+	})
+	log.Printf("Serving the JWKS on http://%s/jwks.json", *addr)
+	log.Fatalln(http.ListenAndServe(*addr, nil))
+}
+
+// sign returns the RS256 JWT of the claims
+func sign(key *rsa.PrivateKey, claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := strings.Join([]string{base64.RawURLEncoding.EncodeToString(header), base64.RawURLEncoding.EncodeToString(payload)}, ".")
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -21,19 +21,15 @@ func main() {
 		log.Fatalln("Error loading .env file")
 	}
 
-	host, port := os.Getenv("HOST"), os.Getenv("PORT")
-	var dbConfig store.DatabaseConfig
-	if err := envconfig.Process("DB", &dbConfig); err != nil {
-		log.Fatalln("Error processing database config: ", err)
+	var args server.HTTPServerArgs
+	if err := envconfig.Process("", &args); err != nil {
+		log.Fatalln("Error processing server config: ", err)
 	}
+	port := args.Port
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
-	s := server.NewHTTPServer(&server.HTTPServerArgs{
-		Host:     host,
-		Port:     port,
-		DbConfig: &dbConfig,
-	})
+	s := server.NewHTTPServer(&args)
 	go func() {
 		fmt.Println("Server is listening on port: ", port)
 		if err = s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/joho/godotenv"
+	"github.com/kelseyhightower/envconfig"
+	_ "github.com/lib/pq"
+	"go-url-short/internal/store"
+	"log"
+	"os"
+	"strconv"
+)
+
+const usage = `usage: go run ./cmd/migrate.go <command>
+
+commands:
+  up        apply every pending migration
+  down [n]  revert the last n migrations (default 1)
+  version   print the current and the latest schema version`
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("Error loading .env file")
+	}
+	if len(os.Args) < 2 {
+		log.Fatalln(usage)
+	}
+
+	var dbConfig store.DatabaseConfig
+	if err := envconfig.Process("DB", &dbConfig); err != nil {
+		log.Fatalln("Error processing database config: ", err)
+	}
+	db, err := sql.Open("postgres", dbConfig.ConnString())
+	if err != nil {
+		log.Fatalln("Error opening database: ", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	switch os.Args[1] {
+	case "up":
+		applied, err := store.MigrateUp(ctx, db)
+		if err != nil {
+			log.Fatalln("Error applying migrations: ", err)
+		}
+		fmt.Printf("Applied %d migrations\n", applied)
+	case "down":
+		steps := 1
+		if len(os.Args) > 2 {
+			if steps, err = strconv.Atoi(os.Args[2]); err != nil || steps < 1 {
+				log.Fatalln(usage)
+			}
+		}
+		reverted, err := store.MigrateDown(ctx, db, steps)
+		if err != nil {
+			log.Fatalln("Error reverting migrations: ", err)
+		}
+		fmt.Printf("Reverted %d migrations\n", reverted)
+	case "version":
+		current, err := store.SchemaVersion(ctx, db)
+		if err != nil {
+			log.Fatalln("Error reading schema version: ", err)
+		}
+		latest, err := store.LatestSchemaVersion()
+		if err != nil {
+			log.Fatalln("Error reading migrations: ", err)
+		}
+		fmt.Printf("Schema version %d, latest %d\n", current, latest)
+	default:
+		log.Fatalln(usage)
+	}
+}
@@ -11,19 +11,15 @@ CREATE USER shorturl_app WITH PASSWORD 'your-password-here';
 CREATE DATABASE shorturl;
 GRANT ALL PRIVILEGES ON DATABASE shorturl TO shorturl_app;
 
--- create table
-CREATE TABLE shorturl.shorturl
-(
-    id         SERIAL PRIMARY KEY,
-    url        VARCHAR(1024) NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
+-- tables are created by the migrations embedded in the binary (internal/store/migrations)
+--   go run ./cmd/migrate.go up
+-- or on startup with DB_AUTOMIGRATE=true
 
 -- show shorturl table owner
 SELECT * from pg_tables WHERE tablename = 'shorturl';
 
--- chanege owner of table
-ALTER TABLE shorturl OWNER TO shorturl_app;
+-- show applied migrations
+select * from schema_migrations order by version;
 
 -- truncate table
 -- truncate table shorturl;
@@ -0,0 +1,229 @@
+package analytics
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchInterval = 5 * time.Second
+	defaultBatchSize     = 500
+)
+
+type BatchConfig struct {
+	Interval time.Duration `default:"5s" desc:"How often buffered clicks are flushed"`
+	Size     int           `default:"500" desc:"Number of buffered click events that triggers an early flush, the most kept while the sink fails"`
+}
+
+// visit identifies the clicks on a short key counted as a single event
+type visit struct {
+	minute    int64
+	referrer  string
+	userAgent string
+	ip        string
+}
+
+func visitOf(click Click) visit {
+	return visit{minute: click.Time.Unix() / 60, referrer: click.Referrer, userAgent: click.UserAgent, ip: click.IP}
+}
+
+// Batcher is a Sink that aggregates clicks in memory per short key and writes them to the underlying sink
+// in batches on an interval or once the buffer is full.
+// The clicks on a key within the same minute with the same referrer, user agent and address are counted
+// as one event: the sinks keep these fields of every event, and the buffer grows with the distinct visitors
+// of the keys rather than with the clicks.
+type Batcher struct {
+	sink   Sink
+	config BatchConfig
+	Log    *log.Logger
+	mu     sync.Mutex
+	// pending counts the clicks of each visit per short key
+	pending map[string]map[visit]int64
+	// count is the number of visits in pending
+	count   int
+	full    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func NewBatcher(sink Sink, config *BatchConfig) *Batcher {
+	l := log.New(log.Writer(), "CLICKBATCHER:", log.LstdFlags)
+	c := BatchConfig{Interval: defaultBatchInterval, Size: defaultBatchSize}
+	if config != nil && config.Interval > 0 {
+		c.Interval = config.Interval
+	}
+	if config != nil && config.Size > 0 {
+		c.Size = config.Size
+	}
+	b := &Batcher{
+		sink:    sink,
+		config:  c,
+		Log:     l,
+		pending: make(map[string]map[visit]int64),
+		full:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go b.loop()
+	return b
+}
+
+func (b *Batcher) loop() {
+	defer close(b.stopped)
+	ticker := time.NewTicker(b.config.Interval)
+	defer ticker.Stop()
+	full := b.full
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		case <-full:
+		}
+		err := b.Flush(context.Background())
+		if err != nil {
+			b.Log.Println("Error flushing clicks: ", err)
+		}
+		// The buffer stays full while the sink fails, the next attempt waits for the interval
+		full = b.full
+		if err != nil {
+			full = nil
+		}
+	}
+}
+
+// add counts the click in its visit, it must be called with the lock held
+func (b *Batcher) add(click Click) {
+	visits := b.pending[click.Key]
+	if visits == nil {
+		visits = make(map[visit]int64)
+		b.pending[click.Key] = visits
+	}
+	v := visitOf(click)
+	if _, found := visits[v]; !found {
+		b.count++
+	}
+	visits[v] += click.clicks()
+}
+
+// Record buffers the click without touching the underlying sink
+func (b *Batcher) Record(ctx context.Context, click Click) error {
+	b.mu.Lock()
+	b.add(click)
+	full := b.count >= b.config.Size
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (b *Batcher) RecordBatch(ctx context.Context, clicks []Click) error {
+	for _, click := range clicks {
+		if err := b.Record(ctx, click); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats adds the clicks still waiting in the buffer to the stats of the underlying sink
+func (b *Batcher) Stats(ctx context.Context, shortKey string) (Stats, error) {
+	stats, err := b.sink.Stats(ctx, shortKey)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	b.mu.Lock()
+	perDay := make(map[string]int64)
+	for v, n := range b.pending[shortKey] {
+		perDay[time.Unix(v.minute*60, 0).UTC().Format(dateLayout)] += n
+	}
+	b.mu.Unlock()
+	if len(perDay) == 0 {
+		return stats, nil
+	}
+
+	for i, d := range stats.Daily {
+		if n, found := perDay[d.Date]; found {
+			stats.Daily[i].Count += n
+			stats.Total += n
+			delete(perDay, d.Date)
+		}
+	}
+	for date, n := range perDay {
+		stats.Daily = append(stats.Daily, DailyCount{Date: date, Count: n})
+		stats.Total += n
+	}
+	sort.Slice(stats.Daily, func(i, j int) bool { return stats.Daily[i].Date < stats.Daily[j].Date })
+	return stats, nil
+}
+
+// Flush writes every buffered event to the underlying sink in one batch.
+// The events of a failed batch are buffered again as long as the buffer stays within its size,
+// the clicks that do not fit are dropped so that they do not pile up while the sink fails.
+func (b *Batcher) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	if b.count == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	pending, count := b.pending, b.count
+	b.pending, b.count = make(map[string]map[visit]int64), 0
+	b.mu.Unlock()
+
+	batch := make([]Click, 0, count)
+	for key, visits := range pending {
+		for v, n := range visits {
+			batch = append(batch, Click{
+				Key:       key,
+				Time:      time.Unix(v.minute*60, 0).UTC(),
+				Referrer:  v.referrer,
+				UserAgent: v.userAgent,
+				IP:        v.ip,
+				Count:     n,
+			})
+		}
+	}
+
+	if err := b.sink.RecordBatch(ctx, batch); err != nil {
+		if dropped := b.requeue(batch); dropped > 0 {
+			b.Log.Printf("Dropped %d clicks that did not fit the buffer again", dropped)
+		}
+		return err
+	}
+	return nil
+}
+
+// requeue buffers the events of a failed batch again until the buffer is full and returns the number of clicks dropped
+func (b *Batcher) requeue(batch []Click) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var dropped int64
+	for _, click := range batch {
+		v := visitOf(click)
+		if _, found := b.pending[click.Key][v]; !found && b.count >= b.config.Size {
+			dropped += click.clicks()
+			continue
+		}
+		b.add(click)
+	}
+	return dropped
+}
+
+// Close stops the background flushes, drains the buffer and closes the underlying sink
+func (b *Batcher) Close() {
+	close(b.done)
+	<-b.stopped
+	if err := b.Flush(context.Background()); err != nil {
+		b.Log.Println("Error draining clicks: ", err)
+	}
+	b.sink.Close()
+}
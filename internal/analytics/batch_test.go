@@ -0,0 +1,90 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"log"
+	"testing"
+	"time"
+)
+
+// failingSink fails its batches while failing is set and records them in InMemSink otherwise
+type failingSink struct {
+	*InMemSink
+	failing bool
+	batches [][]Click
+}
+
+func (s *failingSink) RecordBatch(ctx context.Context, clicks []Click) error {
+	if s.failing {
+		return errors.New("sink unavailable")
+	}
+	s.batches = append(s.batches, clicks)
+	return s.InMemSink.RecordBatch(ctx, clicks)
+}
+
+// newTestBatcher returns a batcher without its background flushes, the test flushes it by itself
+func newTestBatcher(sink Sink, size int) *Batcher {
+	return &Batcher{
+		sink:    sink,
+		config:  BatchConfig{Interval: time.Hour, Size: size},
+		Log:     log.Default(),
+		pending: make(map[string]map[visit]int64),
+		full:    make(chan struct{}, 1),
+	}
+}
+
+func TestBatcherAggregatesAndRequeues(t *testing.T) {
+	ctx := context.Background()
+	sink := &failingSink{InMemSink: NewInMemSink()}
+	b := newTestBatcher(sink, 3)
+
+	now := time.Date(2024, 5, 1, 12, 0, 30, 0, time.UTC)
+	same := Click{Key: "a", Time: now, Referrer: "https://example.com", UserAgent: "bot", IP: "192.0.2.0"}
+	for i := 0; i < 100; i++ {
+		b.Record(ctx, same)
+	}
+	b.Record(ctx, Click{Key: "a", Time: now.Add(time.Minute), IP: "192.0.2.0"})
+	b.Record(ctx, Click{Key: "b", Time: now})
+	if b.count != 3 {
+		t.Errorf("buffered %d events, want the 100 identical clicks counted as one", b.count)
+	}
+
+	stats, err := b.Stats(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 101 || len(stats.Daily) != 1 || stats.Daily[0].Date != "2024-05-01" {
+		t.Errorf("Stats of the buffered clicks = %+v, want 101 clicks on 2024-05-01", stats)
+	}
+
+	// The failed batch is buffered again, merged with the clicks recorded meanwhile
+	sink.failing = true
+	if err := b.Flush(ctx); err == nil {
+		t.Fatal("Flush to a failing sink returned no error")
+	}
+	b.Record(ctx, same)
+	if err := b.Flush(ctx); err == nil {
+		t.Fatal("Flush to a failing sink returned no error")
+	}
+	if stats, _ := b.Stats(ctx, "a"); b.count != 3 || stats.Total != 102 {
+		t.Errorf("buffered %d events and %d clicks of a after the failures, want 3 and 102", b.count, stats.Total)
+	}
+
+	// Beyond the size of the buffer the events of a failed batch are dropped
+	b.Record(ctx, Click{Key: "c", Time: now})
+	if err := b.Flush(ctx); err == nil {
+		t.Fatal("Flush to a failing sink returned no error")
+	}
+	if b.count != 3 {
+		t.Errorf("buffered %d events after a failure with a full buffer, want 3", b.count)
+	}
+
+	sink.failing = false
+	if err := b.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.batches) != 1 || len(sink.batches[0]) != 3 || b.count != 0 {
+		t.Errorf("flushed %v leaving %d events, want a single batch of 3 events", sink.batches, b.count)
+	}
+}
@@ -0,0 +1,74 @@
+package analytics
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+)
+
+type InMemSink struct {
+	mu     sync.Mutex
+	clicks map[string][]Click
+	Log    *log.Logger
+}
+
+func NewInMemSink() *InMemSink {
+	l := log.New(log.Writer(), "INMEMSINK:", log.LstdFlags)
+	l.Println("Creating new in-memory analytics sink")
+	return &InMemSink{
+		clicks: make(map[string][]Click),
+		Log:    l,
+	}
+}
+
+func (s *InMemSink) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clicks = make(map[string][]Click)
+}
+
+func (s *InMemSink) Record(ctx context.Context, click Click) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clicks[click.Key] = append(s.clicks[click.Key], click)
+	return nil
+}
+
+func (s *InMemSink) RecordBatch(ctx context.Context, clicks []Click) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, click := range clicks {
+		s.clicks[click.Key] = append(s.clicks[click.Key], click)
+	}
+	return nil
+}
+
+func (s *InMemSink) Stats(ctx context.Context, shortKey string) (Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return Stats{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	perDay := make(map[string]int64)
+	for _, c := range s.clicks[shortKey] {
+		perDay[c.Time.UTC().Format(dateLayout)] += c.clicks()
+	}
+
+	stats := Stats{Daily: make([]DailyCount, 0, len(perDay))}
+	for date, count := range perDay {
+		stats.Total += count
+		stats.Daily = append(stats.Daily, DailyCount{Date: date, Count: count})
+	}
+	sort.Slice(stats.Daily, func(i, j int) bool { return stats.Daily[i].Date < stats.Daily[j].Date })
+	return stats, nil
+}
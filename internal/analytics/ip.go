@@ -0,0 +1,44 @@
+package analytics
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	ipv4Mask = net.CIDRMask(24, 32)
+	ipv6Mask = net.CIDRMask(48, 128)
+)
+
+// AnonymizeIP drops the host part of the address, keeping the /24 of IPv4 and the /48 of IPv6.
+// It returns an empty string for unparsable addresses.
+func AnonymizeIP(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(ipv4Mask).String()
+	}
+	return ip.Mask(ipv6Mask).String()
+}
+
+// ClientIP returns the address of the client. Behind trustedProxies reverse proxies appending to X-Forwarded-For,
+// it is the entry appended by the farthest of them since the client can forge the entries on the left.
+// Otherwise it is the address of the peer, the source IP of API Gateway under Lambda.
+func ClientIP(r *http.Request, trustedProxies int) string {
+	if forwarded := r.Header.Values("X-Forwarded-For"); trustedProxies > 0 && len(forwarded) > 0 {
+		entries := strings.Split(strings.Join(forwarded, ","), ",")
+		i := len(entries) - trustedProxies
+		if i < 0 {
+			i = 0
+		}
+		return strings.TrimSpace(entries[i])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
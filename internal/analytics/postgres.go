@@ -0,0 +1,110 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	"go-url-short/internal/store"
+	"log"
+	"strings"
+	"time"
+)
+
+// maxBatchRows keeps a multi-row insert well below the 65535 parameters postgres accepts
+const maxBatchRows = 1000
+
+// clickColumns are the columns of a row of clicks, in the order of the arguments of clickArgs
+const clickColumns = 6
+
+func clickArgs(click Click) []any {
+	return []any{click.Key, click.Time, click.Referrer, click.UserAgent, click.IP, click.clicks()}
+}
+
+type PostgresSink struct {
+	db  *sql.DB
+	Log *log.Logger
+}
+
+func NewPostgresSink(config *store.DatabaseConfig) *PostgresSink {
+	l := log.New(log.Writer(), "POSTGRESSINK:", log.LstdFlags)
+	l.Print("Conntected to postgres analytics sink")
+	db, err := sql.Open("postgres", config.ConnString())
+
+	if err != nil {
+		panic(err)
+	}
+
+	return &PostgresSink{
+		Log: l,
+		db:  db,
+	}
+}
+
+func (s *PostgresSink) Close() {
+	s.Log.Println("Closing database connection")
+	s.db.Close()
+}
+
+func (s *PostgresSink) Record(ctx context.Context, click Click) error {
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO clicks (short_key, clicked_at, referrer, user_agent, ip, count) VALUES ($1, $2, $3, $4, $5, $6)",
+		clickArgs(click)...)
+	if err != nil {
+		s.Log.Println("Error inserting click into database: ", err)
+		return err
+	}
+	return nil
+}
+
+// RecordBatch inserts the clicks with multi-row inserts of at most maxBatchRows rows
+func (s *PostgresSink) RecordBatch(ctx context.Context, clicks []Click) error {
+	for start := 0; start < len(clicks); start += maxBatchRows {
+		end := start + maxBatchRows
+		if end > len(clicks) {
+			end = len(clicks)
+		}
+
+		chunk := clicks[start:end]
+		values := make([]string, 0, len(chunk))
+		args := make([]any, 0, len(chunk)*clickColumns)
+		for i, click := range chunk {
+			n := i * clickColumns
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6))
+			args = append(args, clickArgs(click)...)
+		}
+
+		_, err := s.db.ExecContext(ctx,
+			"INSERT INTO clicks (short_key, clicked_at, referrer, user_agent, ip, count) VALUES "+strings.Join(values, ", "),
+			args...)
+		if err != nil {
+			s.Log.Println("Error inserting clicks into database: ", err)
+			return err
+		}
+	}
+
+	s.Log.Printf("Inserted %d clicks into database", len(clicks))
+	return nil
+}
+
+func (s *PostgresSink) Stats(ctx context.Context, shortKey string) (Stats, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT (clicked_at AT TIME ZONE 'UTC')::date AS day, sum(count) FROM clicks
+		WHERE short_key = $1 GROUP BY day ORDER BY day`, shortKey)
+	if err != nil {
+		s.Log.Println("Error querying clicks: ", err)
+		return Stats{}, err
+	}
+	defer rows.Close()
+
+	stats := Stats{Daily: make([]DailyCount, 0)}
+	for rows.Next() {
+		var day time.Time
+		var count int64
+		if err := rows.Scan(&day, &count); err != nil {
+			return Stats{}, err
+		}
+		stats.Total += count
+		stats.Daily = append(stats.Daily, DailyCount{Date: day.Format(dateLayout), Count: count})
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,49 @@
+package analytics
+
+import (
+	"context"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+type Click struct {
+	Key       string
+	Time      time.Time
+	Referrer  string
+	UserAgent string
+	// IP is the anonymized client address, see AnonymizeIP
+	IP string
+	// Count is the number of identical clicks the event stands for, see Batcher. 0 counts as one click.
+	Count int64
+}
+
+// clicks returns the number of clicks the event stands for
+func (c Click) clicks() int64 {
+	if c.Count < 1 {
+		return 1
+	}
+	return c.Count
+}
+
+type DailyCount struct {
+	// Date is the UTC day formatted as 2006-01-02
+	Date  string
+	Count int64
+}
+
+type Stats struct {
+	Total int64
+	Daily []DailyCount
+}
+
+type Sink interface {
+	// Record saves a click on a short key
+	Record(ctx context.Context, click Click) error
+	// RecordBatch saves many clicks at once
+	RecordBatch(ctx context.Context, clicks []Click) error
+	// Stats returns the total and per-day clicks of the short key
+	Stats(ctx context.Context, shortKey string) (Stats, error)
+	// Close releases the sink
+	Close()
+}
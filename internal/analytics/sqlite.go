@@ -0,0 +1,85 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"go-url-short/internal/store"
+	"log"
+)
+
+// SQLiteSink keeps the clicks in the clicks table of the SQLite file of the store, with times as unix milliseconds
+type SQLiteSink struct {
+	db  *sql.DB
+	Log *log.Logger
+}
+
+func NewSQLiteSink(config *store.SQLiteConfig) *SQLiteSink {
+	l := log.New(log.Writer(), "SQLITESINK:", log.LstdFlags)
+	l.Print("Opening sqlite analytics sink ", config.Path)
+	db, err := store.OpenSQLite(config, l)
+	if err != nil {
+		panic(err)
+	}
+
+	return &SQLiteSink{
+		Log: l,
+		db:  db,
+	}
+}
+
+func (s *SQLiteSink) Close() {
+	s.Log.Println("Closing database connection")
+	s.db.Close()
+}
+
+func (s *SQLiteSink) Record(ctx context.Context, click Click) error {
+	return s.RecordBatch(ctx, []Click{click})
+}
+
+// RecordBatch inserts the clicks in a single transaction, SQLite commits are what costs
+func (s *SQLiteSink) RecordBatch(ctx context.Context, clicks []Click) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		s.Log.Println("Error beginning transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx,
+		"INSERT INTO clicks (short_key, clicked_at, referrer, user_agent, ip, count) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		s.Log.Println("Error preparing click insert: ", err)
+		return err
+	}
+	defer stmt.Close()
+
+	for _, click := range clicks {
+		_, err := stmt.ExecContext(ctx, click.Key, click.Time.UnixMilli(), click.Referrer, click.UserAgent, click.IP, click.clicks())
+		if err != nil {
+			s.Log.Println("Error inserting clicks into database: ", err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteSink) Stats(ctx context.Context, shortKey string) (Stats, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT date(clicked_at / 1000, 'unixepoch') AS day, SUM(count) FROM clicks
+		WHERE short_key = ? GROUP BY day ORDER BY day`, shortKey)
+	if err != nil {
+		s.Log.Println("Error querying clicks: ", err)
+		return Stats{}, err
+	}
+	defer rows.Close()
+
+	stats := Stats{Daily: make([]DailyCount, 0)}
+	for rows.Next() {
+		var d DailyCount
+		if err := rows.Scan(&d.Date, &d.Count); err != nil {
+			return Stats{}, err
+		}
+		stats.Total += d.Count
+		stats.Daily = append(stats.Daily, d)
+	}
+	return stats, rows.Err()
+}
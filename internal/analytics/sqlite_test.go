@@ -0,0 +1,41 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"go-url-short/internal/store"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLiteSinkKeepsClicksAcrossRestarts(t *testing.T) {
+	ctx := context.Background()
+	config := &store.SQLiteConfig{Path: filepath.Join(t.TempDir(), "clicks.db")}
+	day := time.Date(2024, 5, 1, 23, 59, 0, 0, time.UTC)
+
+	sink := NewSQLiteSink(config)
+	err := sink.RecordBatch(ctx, []Click{
+		{Key: "a", Time: day, Referrer: "https://example.com", Count: 3},
+		{Key: "a", Time: day.Add(time.Minute)},
+		{Key: "b", Time: day},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Record(ctx, Click{Key: "a", Time: day.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	sink.Close()
+
+	sink = NewSQLiteSink(config)
+	defer sink.Close()
+	stats, err := sink.Stats(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{Total: 5, Daily: []DailyCount{{"2024-05-01", 3}, {"2024-05-02", 2}}}
+	if fmt.Sprint(stats) != fmt.Sprint(want) {
+		t.Errorf("Stats = %+v, want %+v", stats, want)
+	}
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-url-short/internal/store"
+	"time"
+)
+
+// Scopes of the API keys, admin grants every other scope
+const (
+	ScopeCreate    = "create"
+	ScopeReadStats = "read-stats"
+	ScopeAdmin     = "admin"
+)
+
+var scopes = map[string]bool{
+	ScopeCreate:    true,
+	ScopeReadStats: true,
+	ScopeAdmin:     true,
+}
+
+// keyPrefix marks the secrets of the API keys so they are easy to recognize, in leaked logs for instance
+const keyPrefix = "gus_"
+
+// bootstrapKeyID is the ID of the admin key of the configuration, which is not stored
+const bootstrapKeyID = "bootstrap"
+
+type Config struct {
+	Required bool       `default:"false" desc:"Require an API key to shorten and manage links, anonymous requests are allowed otherwise"`
+	AdminKey string     `default:"" desc:"API key with the admin scope that is not stored, to issue the first keys; empty disables it"`
+	JWT      *JWTConfig `envconfig:"JWT"`
+}
+
+var ErrInvalidKey = errors.New("invalid api key")
+var ErrRevokedKey = errors.New("revoked api key")
+var ErrNotMember = errors.New("user is not a member of the workspace")
+
+// HashKey is the hash stored for the secret of an API key.
+// The secrets are random, a fast hash is enough to make a leaked store useless to call the API.
+func HashKey(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// ValidScopes reports an error for the scopes that do not exist
+func ValidScopes(requested []string) error {
+	if len(requested) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range requested {
+		if !scopes[scope] {
+			return fmt.Errorf("unknown scope %s, want %s, %s or %s", scope, ScopeCreate, ScopeReadStats, ScopeAdmin)
+		}
+	}
+	return nil
+}
+
+// Allowed reports whether the key grants the scope
+func Allowed(key store.APIKey, scope string) bool {
+	for _, s := range key.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Issuer issues, authenticates and revokes the API keys kept in the store
+type Issuer struct {
+	store     store.Store
+	adminHash []byte
+}
+
+func NewIssuer(st store.Store, config *Config) *Issuer {
+	i := &Issuer{store: st}
+	if config.AdminKey != "" {
+		i.adminHash = HashKey(config.AdminKey)
+	}
+	return i
+}
+
+// Issue creates a new API key acting for the owner workspace and returns it along with its secret, which is not kept anywhere.
+// Keys issued to a user are limited by the role of the user in the workspace, it returns ErrNotMember when the user has none.
+func (i *Issuer) Issue(ctx context.Context, owner string, userID string, scopes []string) (store.APIKey, string, error) {
+	if owner == "" {
+		return store.APIKey{}, "", fmt.Errorf("owner is required")
+	}
+	if err := ValidScopes(scopes); err != nil {
+		return store.APIKey{}, "", err
+	}
+	if userID != "" {
+		_, err := i.store.GetMember(ctx, owner, userID)
+		if errors.Is(err, store.ErrMemberNotFound) {
+			return store.APIKey{}, "", ErrNotMember
+		}
+		if err != nil {
+			return store.APIKey{}, "", err
+		}
+	}
+
+	id, err := NewID()
+	if err != nil {
+		return store.APIKey{}, "", err
+	}
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return store.APIKey{}, "", err
+	}
+	secret = keyPrefix + secret
+
+	key := store.APIKey{
+		ID:        id,
+		Hash:      HashKey(secret),
+		Owner:     owner,
+		UserID:    userID,
+		Scopes:    scopes,
+		CreatedAt: time.Now().Truncate(time.Millisecond),
+	}
+	if err := i.store.CreateAPIKey(ctx, key); err != nil {
+		return store.APIKey{}, "", err
+	}
+	return key, secret, nil
+}
+
+// Authenticate returns the caller of the secret, ErrInvalidKey for unknown secrets, ErrRevokedKey for revoked keys
+// and ErrNotMember when the user of the key was removed from its workspace.
+func (i *Issuer) Authenticate(ctx context.Context, secret string) (Caller, error) {
+	hash := HashKey(secret)
+	if i.adminHash != nil && subtle.ConstantTimeCompare(hash, i.adminHash) == 1 {
+		return Caller{Key: store.APIKey{ID: bootstrapKeyID, Owner: bootstrapKeyID, Scopes: []string{ScopeAdmin}}}, nil
+	}
+
+	key, err := i.store.GetAPIKey(ctx, hash)
+	if errors.Is(err, store.ErrAPIKeyNotFound) {
+		return Caller{}, ErrInvalidKey
+	}
+	if err != nil {
+		return Caller{}, err
+	}
+	if key.Revoked() {
+		return Caller{}, ErrRevokedKey
+	}
+	if key.UserID == "" {
+		return Caller{Key: key}, nil
+	}
+
+	// The role is read on every request so that role changes and removals apply to the issued keys at once
+	member, err := i.store.GetMember(ctx, key.Owner, key.UserID)
+	if errors.Is(err, store.ErrMemberNotFound) {
+		return Caller{}, ErrNotMember
+	}
+	if err != nil {
+		return Caller{}, err
+	}
+	return Caller{Key: key, Role: member.Role}, nil
+}
+
+func (i *Issuer) List(ctx context.Context) ([]store.APIKey, error) {
+	return i.store.ListAPIKeys(ctx)
+}
+
+func (i *Issuer) Revoke(ctx context.Context, id string) error {
+	return i.store.RevokeAPIKey(ctx, id, time.Now().Truncate(time.Millisecond))
+}
+
+// NewID returns a random ID for the keys, users and workspaces
+func NewID() (string, error) {
+	return randomString(8, hex.EncodeToString)
+}
+
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
@@ -0,0 +1,432 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-url-short/internal/store"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Route groups accepting bearer tokens
+const (
+	GroupAPI   = "api"
+	GroupAdmin = "admin"
+)
+
+type JWTConfig struct {
+	JWKS            string        `default:"" desc:"JWKS of the identity provider, a file or an http(s) URL; empty disables bearer tokens"`
+	Issuer          string        `default:"" desc:"Required iss claim of the tokens, empty accepts every issuer"`
+	Audience        string        `default:"" desc:"Required aud claim of the tokens, empty accepts every audience"`
+	OwnerClaim      string        `default:"sub" desc:"Claim recorded as the owner of the links created with a token"`
+	ScopesClaim     string        `default:"scope" desc:"Claim of the scopes, a space separated string or an array; unknown scopes are ignored"`
+	Groups          []string      `default:"api,admin" desc:"Route groups accepting bearer tokens: api for the links and workspaces routes, admin for the admin routes"`
+	RefreshInterval time.Duration `default:"1h" desc:"How often the JWKS is reloaded, 0 disables the reload"`
+	Leeway          time.Duration `default:"30s" desc:"Clock skew tolerated on the exp and nbf claims"`
+}
+
+var ErrInvalidToken = errors.New("invalid bearer token")
+
+// minRefreshInterval bounds how often a token signed by an unknown key reloads the JWKS,
+// so that forged key IDs cannot hammer the identity provider
+const minRefreshInterval = time.Minute
+
+// maxJWKSSize bounds the JWKS read from a URL
+const maxJWKSSize = 1 << 20
+
+// algorithms maps the supported signing algorithms to their hash, the other ones including none are rejected
+var algorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// jwk is a public key of the JWKS
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey is a parsed key of the JWKS, alg is empty when the JWKS does not restrict the algorithm of the key
+type publicKey struct {
+	key crypto.PublicKey
+	alg string
+}
+
+// Verifier authenticates the callers by the JWTs of an identity provider.
+// The JWKS is reloaded periodically and when a token is signed by an unknown key, a JWKS that fails to load keeps the previous keys.
+type Verifier struct {
+	config    *JWTConfig
+	keys      atomic.Pointer[map[string]publicKey]
+	client    *http.Client
+	mu        sync.Mutex
+	fetchedAt time.Time
+	stop      chan struct{}
+	Log       *log.Logger
+}
+
+func NewVerifier(config *JWTConfig) *Verifier {
+	v := &Verifier{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		stop:   make(chan struct{}),
+		Log:    log.New(log.Writer(), "JWT:", log.LstdFlags),
+	}
+	if err := v.reload(context.Background()); err != nil {
+		panic(err)
+	}
+	if config.RefreshInterval > 0 {
+		go v.reloadLoop(config.RefreshInterval)
+	}
+	return v
+}
+
+func (v *Verifier) reloadLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-v.stop:
+			return
+		case <-ticker.C:
+			if err := v.reload(context.Background()); err != nil {
+				v.Log.Println("Error reloading JWKS, keeping the previous keys: ", err)
+			}
+		}
+	}
+}
+
+func (v *Verifier) reload(ctx context.Context) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.fetchedAt = time.Now()
+
+	content, err := v.fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", v.config.JWKS, err)
+	}
+	keys, err := parseJWKS(content)
+	if err != nil {
+		return fmt.Errorf("%s: %w", v.config.JWKS, err)
+	}
+
+	v.keys.Store(&keys)
+	v.Log.Printf("Loaded %d keys from %s", len(keys), v.config.JWKS)
+	return nil
+}
+
+func (v *Verifier) fetch(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(v.config.JWKS, "http://") && !strings.HasPrefix(v.config.JWKS, "https://") {
+		return os.ReadFile(v.config.JWKS)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.config.JWKS, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, maxJWKSSize))
+}
+
+// parseJWKS keeps the RSA and EC signing keys of the JWKS by their key ID
+func parseJWKS(content []byte) (map[string]publicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			keys[k.Kid] = publicKey{key: key, alg: k.Alg}
+		}
+	}
+	return keys, nil
+}
+
+// publicKey returns nil for the key types that cannot verify the supported algorithms
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on the curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid base64url integer %q", s)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// key returns the key of the key ID, reloading the JWKS once in a while for the unknown ones to pick up rotated keys.
+// Tokens without key ID are accepted when the JWKS has a single key.
+func (v *Verifier) key(ctx context.Context, kid string) (publicKey, bool) {
+	if key, ok := lookupKey(*v.keys.Load(), kid); ok {
+		return key, true
+	}
+
+	v.mu.Lock()
+	stale := time.Since(v.fetchedAt) >= minRefreshInterval
+	v.mu.Unlock()
+	if !stale {
+		return publicKey{}, false
+	}
+	if err := v.reload(ctx); err != nil {
+		v.Log.Println("Error reloading JWKS for an unknown key: ", err)
+		return publicKey{}, false
+	}
+	return lookupKey(*v.keys.Load(), kid)
+}
+
+func lookupKey(keys map[string]publicKey, kid string) (publicKey, bool) {
+	if key, ok := keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	return publicKey{}, false
+}
+
+// Verify checks the signature and the claims of the token and returns its caller, errors wrap ErrInvalidToken.
+// The owner and the scopes of the caller come from the claims of the configuration, unknown scopes are dropped.
+func (v *Verifier) Verify(ctx context.Context, token string) (Caller, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Caller{}, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Caller{}, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	hash, ok := algorithms[header.Alg]
+	if !ok {
+		return Caller{}, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+	key, ok := v.key(ctx, header.Kid)
+	if !ok {
+		return Caller{}, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, header.Kid)
+	}
+	if key.alg != "" && key.alg != header.Alg {
+		return Caller{}, fmt.Errorf("%w: key %q does not sign %s", ErrInvalidToken, header.Kid, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Caller{}, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	if err := verifySignature(header.Alg, hash, key.key, parts[0]+"."+parts[1], signature); err != nil {
+		return Caller{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Caller{}, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return Caller{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	owner, _ := claims[v.config.OwnerClaim].(string)
+	if owner == "" {
+		return Caller{}, fmt.Errorf("%w: missing %s claim", ErrInvalidToken, v.config.OwnerClaim)
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		subject = owner
+	}
+	return Caller{Key: store.APIKey{ID: "jwt:" + subject, Owner: owner, Scopes: knownScopes(claims[v.config.ScopesClaim])}}, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func verifySignature(alg string, hash crypto.Hash, key crypto.PublicKey, signed string, signature []byte) error {
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(k, hash, digest, signature)
+		case "PS":
+			return rsa.VerifyPSS(k, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	case *ecdsa.PublicKey:
+		// ES signatures are r and s as big endian integers of the size of the curve
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || ecCurveSize[alg] != size || len(signature) != 2*size {
+			break
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("key does not sign %s", alg)
+}
+
+// ecCurveSize is the size in bytes of the curve of each ES algorithm
+var ecCurveSize = map[string]int{"ES256": 32, "ES384": 48, "ES512": 66}
+
+func (v *Verifier) checkClaims(claims map[string]any) error {
+	now := time.Now()
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return fmt.Errorf("missing exp claim")
+	}
+	if now.After(exp.Add(v.config.Leeway)) {
+		return fmt.Errorf("token expired")
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Before(nbf.Add(-v.config.Leeway)) {
+		return fmt.Errorf("token not valid yet")
+	}
+
+	if v.config.Issuer != "" && claims["iss"] != v.config.Issuer {
+		return fmt.Errorf("unexpected issuer")
+	}
+	if v.config.Audience != "" && !hasAudience(claims["aud"], v.config.Audience) {
+		return fmt.Errorf("unexpected audience")
+	}
+	return nil
+}
+
+func numericDate(claim any) (time.Time, bool) {
+	seconds, ok := claim.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), true
+}
+
+// hasAudience reads the aud claim, either a string or an array of strings
+func hasAudience(claim any, audience string) bool {
+	switch aud := claim.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// knownScopes reads the scopes claim, either a space separated string or an array of strings
+func knownScopes(claim any) []string {
+	var values []string
+	switch c := claim.(type) {
+	case string:
+		values = strings.Fields(c)
+	case []any:
+		for _, s := range c {
+			if s, ok := s.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	known := make([]string, 0, len(values))
+	for _, s := range values {
+		if scopes[s] {
+			known = append(known, s)
+		}
+	}
+	return known
+}
+
+// Close stops reloading the JWKS
+func (v *Verifier) Close() {
+	close(v.stop)
+}
@@ -0,0 +1,322 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testJWKS serves the public keys of its signing keys, keys added later are served from the next fetch
+type testJWKS struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    map[string]crypto.Signer
+	algs    map[string]string
+	fetches atomic.Int32
+}
+
+func newTestJWKS(t *testing.T) *testJWKS {
+	j := &testJWKS{keys: map[string]crypto.Signer{}, algs: map[string]string{}}
+	j.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j.fetches.Add(1)
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		var keys []jwk
+		for kid, key := range j.keys {
+			k := jwk{Kid: kid, Use: "sig", Alg: j.algs[kid]}
+			switch pub := key.Public().(type) {
+			case *rsa.PublicKey:
+				k.Kty, k.N, k.E = "RSA", encodeBigInt(pub.N), encodeBigInt(big.NewInt(int64(pub.E)))
+			case *ecdsa.PublicKey:
+				k.Kty, k.Crv, k.X, k.Y = "EC", pub.Curve.Params().Name, encodeBigInt(pub.X), encodeBigInt(pub.Y)
+			}
+			keys = append(keys, k)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(j.Close)
+	return j
+}
+
+func encodeBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+// add serves the public key of a new signing key, alg restricts its algorithm unless it is empty
+func (j *testJWKS) add(kid string, alg string, key crypto.Signer) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.keys[kid], j.algs[kid] = key, alg
+}
+
+func (j *testJWKS) verifier(t *testing.T, config JWTConfig) *Verifier {
+	config.JWKS = j.URL
+	if config.OwnerClaim == "" {
+		config.OwnerClaim = "sub"
+	}
+	if config.ScopesClaim == "" {
+		config.ScopesClaim = "scope"
+	}
+	v := NewVerifier(&config)
+	t.Cleanup(v.Close)
+	return v
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newECKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// signToken returns the JWT of the claims signed with the key as alg, the ES signatures are r||s as JWS requires
+func signToken(t *testing.T, alg string, kid string, key crypto.Signer, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	h := algorithms[alg].New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	var signature []byte
+	var err error
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if strings.HasPrefix(alg, "PS") {
+			signature, err = rsa.SignPSS(rand.Reader, k, algorithms[alg], digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			signature, err = rsa.SignPKCS1v15(rand.Reader, k, algorithms[alg], digest)
+		}
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest)
+		size := (k.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// resign replaces the signature of the token
+func resign(token string, signature []byte) string {
+	return token[:strings.LastIndex(token, ".")+1] + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"sub":   "user",
+		"scope": "create read-stats unknown",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestVerifySignatures(t *testing.T) {
+	jwks := newTestJWKS(t)
+	rsaKey := newRSAKey(t)
+	jwks.add("rsa", "", rsaKey)
+	keys := map[string]crypto.Signer{
+		"ES256": newECKey(t, elliptic.P256()),
+		"ES384": newECKey(t, elliptic.P384()),
+		"ES512": newECKey(t, elliptic.P521()),
+	}
+	for alg, key := range keys {
+		jwks.add(alg, alg, key)
+	}
+	v := jwks.verifier(t, JWTConfig{})
+
+	for _, alg := range []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"} {
+		caller, err := v.Verify(context.Background(), signToken(t, alg, "rsa", rsaKey, validClaims()))
+		if err != nil {
+			t.Errorf("%s: %v", alg, err)
+			continue
+		}
+		if caller.Key.ID != "jwt:user" || caller.Key.Owner != "user" || strings.Join(caller.Key.Scopes, " ") != "create read-stats" {
+			t.Errorf("%s: got caller %+v", alg, caller.Key)
+		}
+	}
+	for alg, key := range keys {
+		if _, err := v.Verify(context.Background(), signToken(t, alg, alg, key, validClaims())); err != nil {
+			t.Errorf("%s: %v", alg, err)
+		}
+	}
+}
+
+func TestVerifyRejectsForgedSignatures(t *testing.T) {
+	jwks := newTestJWKS(t)
+	rsaKey, ecKey := newRSAKey(t), newECKey(t, elliptic.P256())
+	jwks.add("rsa", "RS256", rsaKey)
+	jwks.add("ec", "", ecKey)
+	p384Key := newECKey(t, elliptic.P384())
+	jwks.add("p384", "", p384Key)
+	v := jwks.verifier(t, JWTConfig{})
+
+	valid := signToken(t, "RS256", "rsa", rsaKey, validClaims())
+	parts := strings.Split(valid, ".")
+	validES := signToken(t, "ES256", "ec", ecKey, validClaims())
+	esSignature, _ := base64.RawURLEncoding.DecodeString(validES[strings.LastIndex(validES, ".")+1:])
+
+	encode := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	// The HMAC of the token keyed with the public key, accepted by verifiers trusting the alg of the header
+	hmacToken := func(kid string, public []byte) string {
+		signed := encode(map[string]string{"alg": "HS256", "kid": kid}) + "." + parts[1]
+		mac := hmac.New(algorithms["RS256"].New, public)
+		mac.Write([]byte(signed))
+		return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"malformed", "not.a-token"},
+		{"alg none", encode(map[string]string{"alg": "none", "kid": "rsa"}) + "." + parts[1] + "."},
+		{"alg None", encode(map[string]string{"alg": "None", "kid": "rsa"}) + "." + parts[1] + "."},
+		{"HS256 keyed with the RSA modulus", hmacToken("rsa", rsaKey.N.Bytes())},
+		{"HS256 keyed with the JWKS entry", hmacToken("rsa", []byte(encodeBigInt(rsaKey.N)))},
+		{"algorithm the key is not restricted to", signToken(t, "PS256", "rsa", rsaKey, validClaims())},
+		{"RSA algorithm with an EC key", strings.Replace(valid, parts[0], encode(map[string]string{"alg": "RS256", "kid": "ec"}), 1)},
+		{"tampered claims", parts[0] + "." + encode(map[string]any{"sub": "admin", "exp": time.Now().Add(time.Hour).Unix()}) + "." + parts[2]},
+		{"empty signature", parts[0] + "." + parts[1] + "."},
+		{"ES256 with r||s one byte short", resign(validES, esSignature[1:])},
+		{"ES256 with r||s one byte long", resign(validES, append([]byte{0}, esSignature...))},
+		{"ES256 with r and s swapped", resign(validES, append(append([]byte{}, esSignature[32:]...), esSignature[:32]...))},
+		{"ES256 with the key of another curve", signToken(t, "ES256", "p384", p384Key, validClaims())},
+		{"ES384 header on a P-256 key", strings.Replace(validES, validES[:strings.Index(validES, ".")], encode(map[string]string{"alg": "ES384", "kid": "ec"}), 1)},
+	}
+	for _, tt := range tests {
+		if _, err := v.Verify(context.Background(), tt.token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %v, want ErrInvalidToken", tt.name, err)
+		}
+	}
+}
+
+func TestVerifyRefetchesUnknownKeys(t *testing.T) {
+	jwks := newTestJWKS(t)
+	jwks.add("first", "", newRSAKey(t))
+	v := jwks.verifier(t, JWTConfig{})
+	if n := jwks.fetches.Load(); n != 1 {
+		t.Fatalf("got %d fetches, want 1", n)
+	}
+
+	rotated := newRSAKey(t)
+	jwks.add("rotated", "", rotated)
+	token := signToken(t, "RS256", "rotated", rotated, validClaims())
+
+	// The JWKS was just fetched, an unknown key does not fetch it again right away
+	if _, err := v.Verify(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got %v before the refetch, want ErrInvalidToken", err)
+	}
+	if n := jwks.fetches.Load(); n != 1 {
+		t.Errorf("got %d fetches, want 1", n)
+	}
+
+	v.mu.Lock()
+	v.fetchedAt = time.Now().Add(-minRefreshInterval)
+	v.mu.Unlock()
+	if _, err := v.Verify(context.Background(), token); err != nil {
+		t.Errorf("got %v after the refetch", err)
+	}
+	if n := jwks.fetches.Load(); n != 2 {
+		t.Errorf("got %d fetches, want 2", n)
+	}
+
+	// The key is known now, and a key missing from the JWKS stays unknown
+	if _, err := v.Verify(context.Background(), token); err != nil {
+		t.Error(err)
+	}
+	v.mu.Lock()
+	v.fetchedAt = time.Now().Add(-minRefreshInterval)
+	v.mu.Unlock()
+	if _, err := v.Verify(context.Background(), signToken(t, "RS256", "missing", newRSAKey(t), validClaims())); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got %v for a missing key, want ErrInvalidToken", err)
+	}
+	if n := jwks.fetches.Load(); n != 3 {
+		t.Errorf("got %d fetches, want 3", n)
+	}
+}
+
+func TestVerifyClaims(t *testing.T) {
+	jwks := newTestJWKS(t)
+	key := newRSAKey(t)
+	jwks.add("rsa", "", key)
+	v := jwks.verifier(t, JWTConfig{Issuer: "https://issuer.example", Audience: "go-url-short", Leeway: 30 * time.Second})
+
+	now := time.Now()
+	claims := func(changes map[string]any) map[string]any {
+		c := map[string]any{
+			"sub": "user",
+			"iss": "https://issuer.example",
+			"aud": "go-url-short",
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(c, name)
+				continue
+			}
+			c[name] = value
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		claims map[string]any
+		valid  bool
+	}{
+		{"valid", claims(nil), true},
+		{"audience in an array", claims(map[string]any{"aud": []string{"other", "go-url-short"}}), true},
+		{"expired within the leeway", claims(map[string]any{"exp": now.Add(-10 * time.Second).Unix()}), true},
+		{"expired", claims(map[string]any{"exp": now.Add(-time.Minute).Unix()}), false},
+		{"missing exp", claims(map[string]any{"exp": nil}), false},
+		{"not valid yet within the leeway", claims(map[string]any{"nbf": now.Add(10 * time.Second).Unix()}), true},
+		{"not valid yet", claims(map[string]any{"nbf": now.Add(time.Minute).Unix()}), false},
+		{"other issuer", claims(map[string]any{"iss": "https://other.example"}), false},
+		{"missing issuer", claims(map[string]any{"iss": nil}), false},
+		{"other audience", claims(map[string]any{"aud": "other"}), false},
+		{"audience missing from the array", claims(map[string]any{"aud": []string{"other"}}), false},
+		{"missing owner", claims(map[string]any{"sub": nil}), false},
+	}
+	for _, tt := range tests {
+		_, err := v.Verify(context.Background(), signToken(t, "RS256", "rsa", key, tt.claims))
+		if tt.valid && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %v, want ErrInvalidToken", tt.name, err)
+		}
+	}
+}
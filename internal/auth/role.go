@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"go-url-short/internal/store"
+)
+
+// Roles of the members of a workspace, each role grants the scopes of the one below it
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = store.RoleOwner
+)
+
+var roleScopes = map[string][]string{
+	RoleViewer: {ScopeReadStats},
+	RoleEditor: {ScopeReadStats, ScopeCreate},
+	RoleOwner:  {ScopeReadStats, ScopeCreate},
+}
+
+func ValidRole(role string) bool {
+	_, ok := roleScopes[role]
+	return ok
+}
+
+// RoleAllows reports whether the role grants the scope, no role grants the admin scope
+func RoleAllows(role string, scope string) bool {
+	for _, s := range roleScopes[role] {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Caller is the authenticated client of a request
+type Caller struct {
+	Key store.APIKey
+	// Role is the role of the user of the key in its workspace, empty for the keys issued without user
+	Role string
+}
+
+// Workspace is the workspace the caller acts for, empty for anonymous callers
+func (c Caller) Workspace() string {
+	return c.Key.Owner
+}
+
+func (c Caller) Admin() bool {
+	return Allowed(c.Key, ScopeAdmin)
+}
+
+// Allowed reports whether both the key and the role of its user grant the scope
+func (c Caller) Allowed(scope string) bool {
+	if !Allowed(c.Key, scope) {
+		return false
+	}
+	return c.Key.UserID == "" || c.Admin() || RoleAllows(c.Role, scope)
+}
+
+// CanSee reports whether the caller may read the links owned by the workspace,
+// the links without owner are only seen by the admins
+func (c Caller) CanSee(owner string) bool {
+	return c.Admin() || (owner != "" && owner == c.Workspace())
+}
+
+// Manages reports whether the caller may change the members of the workspace
+func (c Caller) Manages(workspaceID string) bool {
+	return c.Admin() || (c.Workspace() == workspaceID && c.Role == RoleOwner)
+}
+
+type contextKey struct{}
+
+// WithCaller returns a copy of the context carrying the authenticated caller
+func WithCaller(ctx context.Context, caller Caller) context.Context {
+	return context.WithValue(ctx, contextKey{}, caller)
+}
+
+// CallerFrom returns the authenticated caller of the context, false for anonymous requests
+func CallerFrom(ctx context.Context) (Caller, bool) {
+	caller, ok := ctx.Value(contextKey{}).(Caller)
+	return caller, ok
+}
@@ -0,0 +1,215 @@
+package policy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"golang.org/x/net/idna"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	File           string        `default:"" desc:"File of the allow and deny rules of the destination domains, empty allows every domain"`
+	ReloadInterval time.Duration `default:"30s" desc:"How often the rules file is checked for changes, 0 disables the reload"`
+}
+
+// BlockedError is returned for the destinations the policy does not allow
+type BlockedError struct {
+	Host string
+	// Rule is the deny rule matching the host, empty when the host matches no allow rule
+	Rule string
+}
+
+func (e *BlockedError) Error() string {
+	if e.Rule == "" {
+		return fmt.Sprintf("destination %s is not in the allowed domains", e.Host)
+	}
+	return fmt.Sprintf("destination %s is blocked by the rule %q", e.Host, e.Rule)
+}
+
+type rule struct {
+	text string
+	// domain matches the host itself, suffix matches its subdomains and pattern the whole host
+	domain  string
+	suffix  string
+	pattern *regexp.Regexp
+}
+
+func (r rule) match(host string) bool {
+	switch {
+	case r.pattern != nil:
+		return r.pattern.MatchString(host)
+	case r.suffix != "":
+		return strings.HasSuffix(host, r.suffix)
+	}
+	return host == r.domain
+}
+
+type rules struct {
+	allow []rule
+	deny  []rule
+}
+
+// parseRules reads one rule per line, blank lines and lines starting with # are ignored:
+//
+//	deny example.com      the domain only
+//	deny *.example.com    every subdomain of the domain, but not the domain itself
+//	deny /^paypa[l1]\./   the hosts matching the regular expression
+//	allow example.org
+//
+// Deny rules win over allow rules. Once there is an allow rule, only the hosts matching one are allowed.
+func parseRules(content []byte) (*rules, error) {
+	rs := &rules{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		action, pattern, _ := strings.Cut(text, " ")
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			return nil, fmt.Errorf("line %d: missing domain: %s", line, text)
+		}
+		r := rule{text: text}
+		switch {
+		case len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/"):
+			re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			r.pattern = re
+		case strings.HasPrefix(pattern, "*."):
+			r.suffix = "." + asciiDomain(pattern[2:])
+		default:
+			r.domain = asciiDomain(pattern)
+		}
+
+		switch action {
+		case "allow":
+			rs.allow = append(rs.allow, r)
+		case "deny":
+			rs.deny = append(rs.deny, r)
+		default:
+			return nil, fmt.Errorf("line %d: unknown action %s, want allow or deny", line, action)
+		}
+	}
+	return rs, scanner.Err()
+}
+
+// asciiDomain lowercases the domain and encodes it to punycode like the hosts of the normalized urls
+func asciiDomain(domain string) string {
+	domain = strings.ToLower(domain)
+	if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+		return ascii
+	}
+	return domain
+}
+
+func (rs *rules) check(host string) error {
+	for _, r := range rs.deny {
+		if r.match(host) {
+			return &BlockedError{Host: host, Rule: r.text}
+		}
+	}
+	if len(rs.allow) == 0 {
+		return nil
+	}
+	for _, r := range rs.allow {
+		if r.match(host) {
+			return nil
+		}
+	}
+	return &BlockedError{Host: host}
+}
+
+// Policy decides which destination domains can be shortened and redirected to.
+// The rules file is reloaded when it changes, a file that fails to load keeps the previous rules.
+type Policy struct {
+	file    string
+	rules   atomic.Pointer[rules]
+	modTime time.Time
+	stop    chan struct{}
+	Log     *log.Logger
+}
+
+func NewPolicy(config *Config) *Policy {
+	p := &Policy{
+		file: config.File,
+		stop: make(chan struct{}),
+		Log:  log.New(log.Writer(), "POLICY:", log.LstdFlags),
+	}
+	p.rules.Store(&rules{})
+	if p.file == "" {
+		return p
+	}
+
+	if err := p.reload(); err != nil {
+		panic(err)
+	}
+	if config.ReloadInterval > 0 {
+		go p.reloadLoop(config.ReloadInterval)
+	}
+	return p
+}
+
+func (p *Policy) reloadLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if err := p.reload(); err != nil {
+				p.Log.Println("Error reloading rules, keeping the previous ones: ", err)
+			}
+		}
+	}
+}
+
+// reload loads the rules file when it changed since the last load
+func (p *Policy) reload() error {
+	info, err := os.Stat(p.file)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(p.modTime) {
+		return nil
+	}
+
+	content, err := os.ReadFile(p.file)
+	if err != nil {
+		return err
+	}
+	rs, err := parseRules(content)
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.file, err)
+	}
+
+	p.rules.Store(rs)
+	p.modTime = info.ModTime()
+	p.Log.Printf("Loaded %d allow and %d deny rules from %s", len(rs.allow), len(rs.deny), p.file)
+	return nil
+}
+
+// Check returns a *BlockedError when the host of the url is not allowed
+func (p *Policy) Check(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	return p.rules.Load().check(strings.ToLower(strings.TrimSuffix(u.Hostname(), ".")))
+}
+
+// Close stops reloading the rules file
+func (p *Policy) Close() {
+	close(p.stop)
+}
@@ -0,0 +1,130 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	rs, err := parseRules([]byte(`
+# phishing
+deny evil.example
+deny *.tracker.example
+deny /^paypa[l1]\./
+
+  deny   Bücher.example
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Policy{}
+	p.rules.Store(rs)
+
+	for _, c := range []struct {
+		url  string
+		rule string
+	}{
+		{"https://example.com/", ""},
+		{"https://evil.example/login", "deny evil.example"},
+		{"https://EVIL.example./", "deny evil.example"},
+		{"https://www.evil.example/", ""},
+		{"https://notevil.example/", ""},
+		{"https://ads.tracker.example/", "deny *.tracker.example"},
+		{"https://a.b.tracker.example/", "deny *.tracker.example"},
+		{"https://tracker.example/", ""},
+		{"https://paypa1.example/", `deny /^paypa[l1]\./`},
+		{"https://paypal.com/", `deny /^paypa[l1]\./`},
+		{"https://www.paypal.com/", ""},
+		{"https://xn--bcher-kva.example/", "deny   Bücher.example"},
+	} {
+		t.Run(c.url, func(t *testing.T) {
+			err := p.Check(c.url)
+			var blocked *BlockedError
+			if c.rule == "" {
+				if err != nil {
+					t.Errorf("Check(%s) = %v, want it allowed", c.url, err)
+				}
+				return
+			}
+			if !errors.As(err, &blocked) || blocked.Rule != c.rule {
+				t.Errorf("Check(%s) = %v, want it blocked by %q", c.url, err, c.rule)
+			}
+		})
+	}
+}
+
+func TestCheckAllowRules(t *testing.T) {
+	rs, err := parseRules([]byte("allow example.com\nallow *.example.org\ndeny blocked.example.org\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		host    string
+		allowed bool
+	}{
+		{"example.com", true},
+		{"www.example.com", false},
+		{"docs.example.org", true},
+		{"example.org", false},
+		{"blocked.example.org", false},
+		{"other.example", false},
+	} {
+		err := rs.check(c.host)
+		if allowed := err == nil; allowed != c.allowed {
+			t.Errorf("check(%s) = %v, want allowed %v", c.host, err, c.allowed)
+		}
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, content := range []string{
+		"deny",
+		"block example.com",
+		"deny /paypa[l/",
+	} {
+		if _, err := parseRules([]byte(content)); err == nil {
+			t.Errorf("parseRules(%q) returned no error", content)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules")
+	write := func(content string, modTime time.Time) {
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-time.Hour)
+	write("deny evil.example\n", start)
+
+	p := NewPolicy(&Config{File: file})
+	defer p.Close()
+	if p.Check("https://evil.example/") == nil {
+		t.Fatal("the rules of the file were not loaded")
+	}
+
+	write("deny other.example\n", start.Add(time.Minute))
+	if err := p.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Check("https://evil.example/") != nil || p.Check("https://other.example/") == nil {
+		t.Error("the changed rules were not reloaded")
+	}
+
+	// A file that fails to load keeps the previous rules
+	write("block evil.example\n", start.Add(2*time.Minute))
+	if err := p.reload(); err == nil {
+		t.Error("reload of an invalid file returned no error")
+	}
+	if p.Check("https://other.example/") == nil {
+		t.Error("the previous rules were dropped by an invalid file")
+	}
+}
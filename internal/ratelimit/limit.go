@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"go-url-short/internal/store"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limiter backends
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+type Config struct {
+	Backend  string `default:"memory" desc:"Where the token buckets are kept: memory, per instance, or redis, shared by every instance"`
+	Shorten  Limit  `default:"30/m" desc:"Links created per client, as a count per s, m or h, 0 disables the limit"`
+	Batch    Limit  `default:"10/m" desc:"Batches of links created per client, as a count per s, m or h, 0 disables the limit"`
+	Redirect Limit  `default:"600/m" desc:"Redirects per client, as a count per s, m or h, 0 disables the limit"`
+	Links    Limit  `default:"120/m" desc:"Requests to the /links, /workspaces and /admin routes per client, as a count per s, m or h, 0 disables the limit"`
+}
+
+// Limit is a token bucket refilled with Count tokens every Period, holding at most Count tokens.
+// It is read from strings such as 30/m, 5/s or 1000/h, 0 disables the limit.
+type Limit struct {
+	Count  int
+	Period time.Duration
+}
+
+var periods = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// Decode implements envconfig.Decoder
+func (l *Limit) Decode(value string) error {
+	if value == "" || value == "0" {
+		*l = Limit{}
+		return nil
+	}
+
+	count, unit, found := strings.Cut(value, "/")
+	n, err := strconv.Atoi(count)
+	period, ok := periods[unit]
+	if !found || err != nil || n < 0 || !ok {
+		return fmt.Errorf("invalid rate limit %q, want a count per s, m or h such as 30/m", value)
+	}
+	*l = Limit{Count: n, Period: period}
+	return nil
+}
+
+func (l Limit) String() string {
+	for unit, period := range periods {
+		if l.Period == period {
+			return fmt.Sprintf("%d/%s", l.Count, unit)
+		}
+	}
+	return "0"
+}
+
+func (l Limit) Disabled() bool {
+	return l.Count <= 0 || l.Period <= 0
+}
+
+// rate is the number of tokens added per millisecond
+func (l Limit) rate() float64 {
+	return float64(l.Count) / float64(l.Period.Milliseconds())
+}
+
+type Result struct {
+	Allowed bool
+	// Remaining is the number of requests left right now
+	Remaining int
+	// RetryAfter is how long to wait for the next token when the request is not allowed
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// result describes the bucket holding the tokens left after the request
+func (l Limit) result(allowed bool, tokens float64) Result {
+	res := Result{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(l.Count) - tokens) / l.rate() * float64(time.Millisecond)),
+	}
+	if !allowed {
+		res.RetryAfter = time.Duration((1 - tokens) / l.rate() * float64(time.Millisecond))
+	}
+	return res
+}
+
+// Limiter takes a token from the bucket of the key
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// NewLimiter returns the limiter of the configured backend, the redis one connects with the redis settings of the stores
+func NewLimiter(config *Config, redisConfig *store.RedisConfig) Limiter {
+	switch config.Backend {
+	case BackendMemory:
+		return NewMemoryLimiter()
+	case BackendRedis:
+		if redisConfig.Addr == "" {
+			panic(fmt.Errorf("the redis rate limiter requires a redis address"))
+		}
+		return NewRedisLimiter(store.NewRedisClient(redisConfig))
+	}
+	panic(fmt.Errorf("unknown rate limiter backend: %s", config.Backend))
+}
@@ -0,0 +1,155 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLimitDecode(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		want  Limit
+	}{
+		{"30/m", Limit{30, time.Minute}},
+		{"5/s", Limit{5, time.Second}},
+		{"1000/h", Limit{1000, time.Hour}},
+		{"0/m", Limit{0, time.Minute}},
+		{"0", Limit{}},
+		{"", Limit{}},
+	} {
+		var l Limit
+		if err := l.Decode(c.value); err != nil {
+			t.Errorf("Decode(%q) returned %v", c.value, err)
+			continue
+		}
+		if l != c.want {
+			t.Errorf("Decode(%q) = %+v, want %+v", c.value, l, c.want)
+		}
+	}
+
+	for _, value := range []string{"30", "30/d", "-1/m", "x/m", "30/"} {
+		var l Limit
+		if err := l.Decode(value); err == nil {
+			t.Errorf("Decode(%q) returned no error", value)
+		}
+	}
+}
+
+// newTestRedisLimiter returns a limiter on an in-process server speaking the redis protocol
+func newTestRedisLimiter(t *testing.T) (*RedisLimiter, *miniredis.Miniredis) {
+	m := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisLimiter(client), m
+}
+
+func TestLimiters(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		limiter func(t *testing.T) Limiter
+	}{
+		{"memory", func(t *testing.T) Limiter { return NewMemoryLimiter() }},
+		{"redis", func(t *testing.T) Limiter {
+			l, _ := newTestRedisLimiter(t)
+			return l
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			l := c.limiter(t)
+			limit := Limit{Count: 3, Period: time.Hour}
+
+			// The bucket starts full and empties one request at a time
+			for i := 2; i >= 0; i-- {
+				res, err := l.Allow(ctx, "client", limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !res.Allowed || res.Remaining != i {
+					t.Fatalf("Allow = %+v, want allowed with %d remaining", res, i)
+				}
+			}
+			res, err := l.Allow(ctx, "client", limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A token comes back every 20 minutes
+			if res.Allowed || res.Remaining != 0 || res.RetryAfter <= 19*time.Minute || res.RetryAfter > 20*time.Minute {
+				t.Errorf("Allow of an empty bucket = %+v, want refused for about 20m", res)
+			}
+			if res.Reset <= 59*time.Minute || res.Reset > time.Hour {
+				t.Errorf("Allow of an empty bucket resets in %s, want about 1h", res.Reset)
+			}
+
+			// Every key has a bucket of its own
+			res, err = l.Allow(ctx, "other", limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.Allowed || res.Remaining != 2 {
+				t.Errorf("Allow of another key = %+v, want allowed with 2 remaining", res)
+			}
+		})
+	}
+}
+
+func TestMemoryLimiterRefills(t *testing.T) {
+	ctx := context.Background()
+	l := NewMemoryLimiter()
+	limit := Limit{Count: 2, Period: time.Hour}
+	for i := 0; i < 3; i++ {
+		l.Allow(ctx, "client", limit)
+	}
+
+	// Over half the period later the bucket earned a token back
+	l.buckets["client"].last = l.buckets["client"].last.Add(-31 * time.Minute)
+	if res, _ := l.Allow(ctx, "client", limit); !res.Allowed || res.Remaining != 0 {
+		t.Errorf("Allow over half the period later = %+v, want allowed with 0 remaining", res)
+	}
+
+	// The full buckets are swept as they are the same as no bucket
+	l.Allow(ctx, "idle", limit)
+	l.buckets["idle"].last = l.buckets["idle"].last.Add(-time.Hour)
+	l.lastSweep = l.lastSweep.Add(-2 * sweepInterval)
+	l.Allow(ctx, "client", limit)
+	if _, found := l.buckets["idle"]; found {
+		t.Error("the full bucket was not swept")
+	}
+	if _, found := l.buckets["client"]; !found {
+		t.Error("the bucket in use was swept")
+	}
+}
+
+func TestRedisLimiterRefills(t *testing.T) {
+	ctx := context.Background()
+	l, m := newTestRedisLimiter(t)
+	limit := Limit{Count: 2, Period: time.Hour}
+	for i := 0; i < 3; i++ {
+		if _, err := l.Allow(ctx, "client", limit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The bucket expires once it would be full again
+	if ttl := m.TTL(redisPrefix + "client"); ttl <= 0 || ttl > time.Hour+time.Second {
+		t.Errorf("bucket expires in %s, want within the period", ttl)
+	}
+
+	// Over half the period later the bucket earned a token back
+	last, err := strconv.ParseInt(m.HGet(redisPrefix+"client", "last"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.HSet(redisPrefix+"client", "last", strconv.FormatInt(last-(31*time.Minute).Milliseconds(), 10))
+	res, err := l.Allow(ctx, "client", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed || res.Remaining != 0 {
+		t.Errorf("Allow over half the period later = %+v, want allowed with 0 remaining", res)
+	}
+}
@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+const redisPrefix = "ratelimit:"
+
+// redisAllowScript refills and takes a token from the bucket hash of KEYS[1].
+// ARGV are the tokens per millisecond, the bucket size and the current time in milliseconds.
+// It returns whether the token was taken and the tokens left, as a string to keep the fraction.
+var redisAllowScript = redis.NewScript(`
+local rate, size, now = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+local last = tonumber(redis.call('HGET', KEYS[1], 'last'))
+if tokens == nil or last == nil then
+	tokens, last = size, now
+end
+tokens = math.min(size, tokens + math.max(0, now - last) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(size / rate))
+return {allowed, tostring(tokens)}`)
+
+// RedisLimiter keeps the buckets in redis so that every instance shares them
+type RedisLimiter struct {
+	client *redis.Client
+}
+
+func NewRedisLimiter(client *redis.Client) *RedisLimiter {
+	return &RedisLimiter{client: client}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	res, err := redisAllowScript.Run(ctx, l.client, []string{redisPrefix + key},
+		limit.rate(), limit.Count, time.Now().UnixMilli()).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	allowed, _ := res[0].(int64)
+	text, _ := res[1].(string)
+	tokens, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return Result{}, err
+	}
+	return limit.result(allowed == 1, tokens), nil
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"go-url-short/internal/urlnorm"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// apiPrefix is the prefix of the versioned routes, the routes without it are kept for compatibility
+const apiPrefix = "/v1"
+
+// Error codes of the /v1 error envelope, the urls rejected by the normalizer have the codes of urlnorm
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeInvalidJSON          = "invalid_json"
+	CodeMissingParameter     = "missing_parameter"
+	CodeInvalidAlias         = "invalid_alias"
+	CodeInvalidExpiry        = "invalid_expiry"
+	CodeInvalidLimit         = "invalid_limit"
+	CodeInvalidCursor        = "invalid_cursor"
+	CodeInvalidScope         = "invalid_scope"
+	CodeInvalidRole          = "invalid_role"
+	CodeEmptyBatch           = "empty_batch"
+	CodeBatchTooLarge        = "batch_too_large"
+	CodeUnauthorized         = "unauthorized"
+	CodeInvalidAPIKey        = "invalid_api_key"
+	CodeRevokedAPIKey        = "revoked_api_key"
+	CodeInvalidToken         = "invalid_token"
+	CodeForbidden            = "forbidden"
+	CodeInsufficientScope    = "insufficient_scope"
+	CodeNotMember            = "not_a_member"
+	CodeBlockedDestination   = "blocked_destination"
+	CodeNotFound             = "not_found"
+	CodeLinkNotFound         = "link_not_found"
+	CodeAPIKeyNotFound       = "api_key_not_found"
+	CodeUserNotFound         = "user_not_found"
+	CodeWorkspaceNotFound    = "workspace_not_found"
+	CodeMemberNotFound       = "member_not_found"
+	CodeLinkExpired          = "link_expired"
+	CodeAliasTaken           = "alias_taken"
+	CodeLastOwner            = "last_owner"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeNotAcceptable        = "not_acceptable"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeRateLimited          = "rate_limited"
+	CodeKeyGenerationFailed  = "key_generation_failed"
+	CodeInternal             = "internal_error"
+)
+
+// versioned reports whether the request is made to the /v1 API
+func versioned(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, apiPrefix+"/")
+}
+
+// writeError answers with the error envelope on the /v1 API and with the former {"error": message} otherwise
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if versioned(r) {
+		json.NewEncoder(w).Encode(&ErrorEnvelope{APIError{Code: code, Message: message}})
+		return
+	}
+	json.NewEncoder(w).Encode(&ErrorResponse{message})
+}
+
+// writeInvalidURL answers with the reason the normalizer rejected the url
+func writeInvalidURL(w http.ResponseWriter, r *http.Request, invalid *InvalidURLResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	if versioned(r) {
+		json.NewEncoder(w).Encode(&ErrorEnvelope{APIError{Code: invalid.Code, Message: invalid.Error, Url: invalid.Url}})
+		return
+	}
+	json.NewEncoder(w).Encode(invalid)
+}
+
+// invalidURLResponse describes why the url was rejected by the normalizer
+func invalidURLResponse(originalURL string, err error) *InvalidURLResponse {
+	code := urlnorm.CodeInvalid
+	var urlErr *urlnorm.Error
+	if errors.As(err, &urlErr) {
+		code = urlErr.Code
+	}
+	return &InvalidURLResponse{Error: err.Error(), Code: code, Url: originalURL}
+}
+
+// negotiate checks that the /v1 requests send JSON or form bodies and accept JSON responses
+func (s *httpServer) negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		if !acceptsJSON(r.Header.Get("Accept")) {
+			writeError(w, r, http.StatusNotAcceptable, CodeNotAcceptable, "Responses are only available as application/json")
+			return
+		}
+
+		if contentType := r.Header.Get("Content-Type"); contentType != "" {
+			mediaType, _, err := mime.ParseMediaType(contentType)
+			if err != nil || (mediaType != "application/json" && mediaType != "application/x-www-form-urlencoded" && mediaType != "multipart/form-data") {
+				writeError(w, r, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Request bodies must be application/json or forms, not "+contentType)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acceptsJSON reports whether the Accept header allows application/json, a missing header accepts anything
+func acceptsJSON(accept string) bool {
+	if accept == "" {
+		return true
+	}
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil || params["q"] == "0" || params["q"] == "0.0" || params["q"] == "0.00" || params["q"] == "0.000" {
+			continue
+		}
+		if mediaType == "application/json" || mediaType == "application/*" || mediaType == "*/*" {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeRequest reads the parameters of the request into the fields of dst by their json name,
+// from a JSON body or else from the form and query parameters where lists are comma separated
+func decodeRequest(r *http.Request, dst any) error {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(dst); err != nil && err != io.EOF {
+			return err
+		}
+		return nil
+	}
+
+	v := reflect.ValueOf(dst).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		value := r.FormValue(name)
+		switch field := v.Field(i); field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Slice:
+			if value != "" {
+				field.Set(reflect.ValueOf(strings.Split(value, ",")))
+			}
+		}
+	}
+	return nil
+}
+
+// writeDecodeError answers the requests whose JSON body cannot be decoded
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	writeError(w, r, http.StatusBadRequest, CodeInvalidJSON, "Invalid JSON body: "+err.Error())
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"go-url-short/internal/auth"
+	"go-url-short/internal/store"
+	"net/http"
+	"strings"
+)
+
+// bearer authenticates the requests carrying a bearer token in the Authorization header,
+// the other requests are left to the API key of authenticate
+func (s *httpServer) bearer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		caller, err := s.Tokens.Verify(r.Context(), strings.TrimSpace(token))
+		if err != nil {
+			s.Log.Println("Rejected bearer token: ", err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeError(w, r, http.StatusUnauthorized, CodeInvalidToken, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithCaller(r.Context(), caller)))
+	})
+}
+
+// authenticate resolves the API key of the X-API-Key header and checks that it grants the scope.
+// Requests without key go through anonymously unless keys are required, the admin scope always requires one.
+// Callers already authenticated by a bearer token only have their scope checked.
+func (s *httpServer) authenticate(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if caller, ok := auth.CallerFrom(r.Context()); ok {
+			if !caller.Allowed(scope) {
+				writeError(w, r, http.StatusForbidden, CodeInsufficientScope, "Bearer token is missing the "+scope+" scope")
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		secret := r.Header.Get("X-API-Key")
+		if secret == "" {
+			if s.AuthRequired || scope == auth.ScopeAdmin {
+				writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Missing X-API-Key header")
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		caller, err := s.Keys.Authenticate(r.Context(), secret)
+		if errors.Is(err, auth.ErrInvalidKey) {
+			writeError(w, r, http.StatusUnauthorized, CodeInvalidAPIKey, err.Error())
+			return
+		}
+		if errors.Is(err, auth.ErrRevokedKey) {
+			writeError(w, r, http.StatusUnauthorized, CodeRevokedAPIKey, err.Error())
+			return
+		}
+		if errors.Is(err, auth.ErrNotMember) {
+			writeError(w, r, http.StatusForbidden, CodeNotMember, err.Error())
+			return
+		}
+		if err != nil {
+			s.Log.Println("Error authenticating api key: ", err)
+			writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+			return
+		}
+
+		if !auth.Allowed(caller.Key, scope) {
+			writeError(w, r, http.StatusForbidden, CodeInsufficientScope, "API key is missing the "+scope+" scope")
+			return
+		}
+		if !caller.Allowed(scope) {
+			writeError(w, r, http.StatusForbidden, CodeInsufficientScope, "The "+caller.Role+" role does not grant the "+scope+" scope")
+			return
+		}
+		next(w, r.WithContext(auth.WithCaller(r.Context(), caller)))
+	}
+}
+
+// checkOwner writes the error response and returns false when the request may not change the link.
+// Links created with an API key can only be changed with a key of the same workspace or an admin key,
+// the anonymous links only with an admin key.
+func (s *httpServer) checkOwner(w http.ResponseWriter, r *http.Request, shortKey string) bool {
+	link, err := s.Store.GetLink(r.Context(), shortKey)
+	if err != nil && errors.Is(err, store.ErrKeyNotFound) {
+		writeError(w, r, http.StatusNotFound, CodeLinkNotFound, "Not Found key("+shortKey+")")
+		return false
+	}
+
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return false
+	}
+
+	caller, _ := auth.CallerFrom(r.Context())
+	if link.Owner == "" && !caller.Admin() {
+		writeError(w, r, http.StatusForbidden, CodeForbidden, "key("+shortKey+") has no owner, only an admin key may change it")
+		return false
+	}
+
+	if !caller.CanSee(link.Owner) {
+		writeError(w, r, http.StatusForbidden, CodeForbidden, "key("+shortKey+") belongs to another workspace")
+		return false
+	}
+	return true
+}
+
+// linkOwner is the workspace recorded on the links created by the request, empty for anonymous requests
+func linkOwner(r *http.Request) string {
+	caller, _ := auth.CallerFrom(r.Context())
+	return caller.Workspace()
+}
+
+func (s *httpServer) handleIssueKey(w http.ResponseWriter, r *http.Request) {
+	var req IssueKeyRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	owner, scopes := req.Owner, req.Scopes
+	if owner == "" {
+		writeError(w, r, http.StatusBadRequest, CodeMissingParameter, "Missing owner params")
+		return
+	}
+
+	if err := auth.ValidScopes(scopes); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidScope, err.Error())
+		return
+	}
+
+	key, secret, err := s.Keys.Issue(r.Context(), owner, req.User, scopes)
+	if err != nil && errors.Is(err, auth.ErrNotMember) {
+		writeError(w, r, http.StatusBadRequest, CodeNotMember, "user("+req.User+") is not a member of workspace("+owner+")")
+		return
+	}
+
+	if err != nil {
+		s.Log.Println("Error issuing api key: ", err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	s.Log.Printf("Issued api key(%s) to %s", key.ID, key.Owner)
+	response := apiKeyResponse(key)
+	response.Key = secret
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&response)
+}
+
+func (s *httpServer) handleListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.Keys.List(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	response := ListAPIKeysResponse{Keys: make([]APIKeyResponse, 0, len(keys))}
+	for _, key := range keys {
+		response.Keys = append(response.Keys, apiKeyResponse(key))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&response)
+}
+
+func (s *httpServer) handleRevokeKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	err := s.Keys.Revoke(r.Context(), id)
+	if err != nil && errors.Is(err, store.ErrAPIKeyNotFound) {
+		writeError(w, r, http.StatusNotFound, CodeAPIKeyNotFound, "Not Found api key("+id+")")
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	s.Log.Printf("Revoked api key(%s)", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiKeyResponse(key store.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:        key.ID,
+		Owner:     key.Owner,
+		User:      key.UserID,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt,
+		RevokedAt: timeOrNil(key.RevokedAt),
+	}
+}
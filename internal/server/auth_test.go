@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"go-url-short/internal/auth"
+	"go-url-short/internal/policy"
+	"go-url-short/internal/shorten"
+	"go-url-short/internal/store"
+	"go-url-short/internal/urlnorm"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOwnerlessLinksOnlyChangedByAdmins(t *testing.T) {
+	ctx := context.Background()
+	gen, err := shorten.NewKeyGenerator(&shorten.KeyGeneratorConfig{Strategy: shorten.StrategyRandom, Length: 6}, shorten.StrategyRandom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewInMemStore(nil, gen)
+	defer st.DbClose(ctx)
+	s := &httpServer{
+		Log:    log.Default(),
+		Store:  st,
+		URLs:   urlnorm.NewNormalizer(&urlnorm.Config{Schemes: []string{"http", "https"}, MaxLength: 1024}),
+		Policy: policy.NewPolicy(&policy.Config{}),
+	}
+
+	anonymous, err := st.Set(ctx, "https://example.com/anonymous", store.SetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	owned, err := st.Set(ctx, "https://example.com/owned", store.SetOptions{Owner: "team"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callers := map[string]*auth.Caller{
+		"anonymous": nil,
+		"workspace": {Key: store.APIKey{Owner: "team", Scopes: []string{auth.ScopeCreate, auth.ScopeReadStats}}},
+		"other":     {Key: store.APIKey{Owner: "other", Scopes: []string{auth.ScopeCreate, auth.ScopeReadStats}}},
+		"admin":     {Key: store.APIKey{Owner: "ops", Scopes: []string{auth.ScopeAdmin}}},
+	}
+	request := func(caller string, method string, key string, body string) *http.Request {
+		req := httptest.NewRequest(method, "/v1/links/"+key, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = mux.SetURLVars(req, map[string]string{"key": key})
+		if c := callers[caller]; c != nil {
+			req = req.WithContext(auth.WithCaller(req.Context(), *c))
+		}
+		return req
+	}
+
+	for _, c := range []struct {
+		caller string
+		key    string
+		want   int
+	}{
+		{"anonymous", anonymous, http.StatusForbidden},
+		{"workspace", anonymous, http.StatusForbidden},
+		{"anonymous", owned, http.StatusForbidden},
+		{"other", owned, http.StatusForbidden},
+		{"workspace", owned, http.StatusOK},
+		{"admin", anonymous, http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		s.handleUpdateLink(w, request(c.caller, http.MethodPut, c.key, `{"url":"https://example.com/elsewhere"}`))
+		if w.Code != c.want {
+			t.Errorf("%s updating %s: got status %d, want %d", c.caller, c.key, w.Code, c.want)
+		}
+	}
+
+	for _, caller := range []string{"anonymous", "workspace"} {
+		w := httptest.NewRecorder()
+		s.handleDeleteLink(w, request(caller, http.MethodDelete, anonymous, ""))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s deleting the anonymous link: got status %d, want 403", caller, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.handleListLinks(w, httptest.NewRequest(http.MethodGet, "/v1/links", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous listing the links: got status %d, want 401", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.handleDeleteLink(w, request("admin", http.MethodDelete, anonymous, ""))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("admin deleting the anonymous link: got status %d, want 204", w.Code)
+	}
+}
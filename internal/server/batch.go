@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-url-short/internal/store"
+	"net/http"
+)
+
+// maxBatchItemBytes bounds the size of the body of a batch, per item
+const maxBatchItemBytes = 16 << 10
+
+// handleShortenBatch shortens a JSON array of urls with a single SetBatch.
+// Each url gets its own result and status, the urls failing validation or taken aliases do not fail the others.
+func (s *httpServer) handleShortenBatch(w http.ResponseWriter, r *http.Request) {
+	var requests []ShortenRequest
+	r.Body = http.MaxBytesReader(w, r.Body, int64(s.MaxBatch)*maxBatchItemBytes)
+	if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, CodeBatchTooLarge, "Batch body is too large")
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, CodeInvalidJSON, "Batch must be a JSON array of urls: "+err.Error())
+		return
+	}
+
+	if len(requests) == 0 {
+		writeError(w, r, http.StatusBadRequest, CodeEmptyBatch, "Batch has no urls")
+		return
+	}
+
+	if len(requests) > s.MaxBatch {
+		writeError(w, r, http.StatusRequestEntityTooLarge, CodeBatchTooLarge, fmt.Sprintf("Batch has %d urls, at most %d are allowed", len(requests), s.MaxBatch))
+		return
+	}
+
+	// Only the valid urls go to the store, index maps them back to their request
+	response := BatchResponse{Results: make([]BatchItemResponse, len(requests))}
+	items := make([]store.BatchItem, 0, len(requests))
+	index := make([]int, 0, len(requests))
+	for i, req := range requests {
+		item, linkErr := s.newLink(r, req)
+		if linkErr != nil {
+			response.Results[i] = BatchItemResponse{Url: req.Url, Status: linkErr.status, Error: linkErr.message, Code: linkErr.code}
+			continue
+		}
+		items = append(items, item)
+		index = append(index, i)
+	}
+
+	// The items past the results of a failed batch were not saved, they fail with its error
+	results, err := s.Store.SetBatch(r.Context(), items)
+	if err != nil {
+		s.Log.Printf("Error shortening batch, %d of %d urls saved: %v", len(results), len(items), err)
+	}
+
+	created := 0
+	for j, item := range items {
+		result := BatchItemResponse{Url: item.URL}
+		itemErr := err
+		if j < len(results) {
+			itemErr = results[j].Err
+		}
+		if itemErr != nil {
+			result.Status, result.Error, result.Code = batchItemError(itemErr, item)
+		} else {
+			result.Status, result.ShortUrl, result.ExpiresAt = http.StatusCreated, shortURL(r, results[j].Key), timeOrNil(item.Opts.ExpiresAt)
+			created++
+		}
+		response.Results[index[j]] = result
+	}
+
+	s.Log.Printf("Generated %d short urls from a batch of %d", created, len(requests))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&response)
+}
+
+// batchItemError returns the status, message and code of the item failed by the error
+func batchItemError(err error, item store.BatchItem) (int, string, string) {
+	switch {
+	case errors.Is(err, store.ErrKeyAlreadyExists):
+		return http.StatusConflict, "Already exists key(" + item.Opts.Alias + ")", CodeAliasTaken
+	case errors.Is(err, store.ErrKeyGenerationFailed):
+		return http.StatusServiceUnavailable, "No free key found, retry later", CodeKeyGenerationFailed
+	}
+	return http.StatusInternalServerError, "Unhandled Error", CodeInternal
+}
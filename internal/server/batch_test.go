@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-url-short/internal/policy"
+	"go-url-short/internal/shorten"
+	"go-url-short/internal/store"
+	"go-url-short/internal/urlnorm"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingBatchStore saves the first item of a batch and then fails it, like a connection lost halfway
+type failingBatchStore struct {
+	store.Store
+}
+
+var errConnectionLost = errors.New("connection lost")
+
+func (s failingBatchStore) SetBatch(ctx context.Context, items []store.BatchItem) ([]store.BatchResult, error) {
+	key, err := s.Store.Set(ctx, items[0].URL, items[0].Opts)
+	return []store.BatchResult{{Key: key, Err: err}}, errConnectionLost
+}
+
+func TestShortenBatchPartialFailure(t *testing.T) {
+	gen, err := shorten.NewKeyGenerator(&shorten.KeyGeneratorConfig{Strategy: shorten.StrategyRandom, Length: 6}, shorten.StrategyRandom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := failingBatchStore{store.NewInMemStore(nil, gen)}
+	defer st.DbClose(context.Background())
+	s := &httpServer{
+		Log:      log.Default(),
+		Store:    st,
+		URLs:     urlnorm.NewNormalizer(&urlnorm.Config{Schemes: []string{"http", "https"}, MaxLength: 1024}),
+		Policy:   policy.NewPolicy(&policy.Config{}),
+		MaxBatch: 10,
+	}
+
+	body := `[{"url":"https://example.com/saved"},{"url":"ftp://example.com"},{"url":"https://example.com/lost"}]`
+	req := httptest.NewRequest(http.MethodPost, "/v1/shorten/batch", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	s.handleShortenBatch(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", w.Code)
+	}
+	var response BatchResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		status int
+		code   string
+	}{
+		{http.StatusCreated, ""},
+		{http.StatusBadRequest, urlnorm.CodeSchemeNotAllowed},
+		{http.StatusInternalServerError, CodeInternal},
+	}
+	if len(response.Results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(response.Results), len(want))
+	}
+	for i, w := range want {
+		if got := response.Results[i]; got.Status != w.status || got.Code != w.code {
+			t.Errorf("result %d: got %d %q, want %d %q", i, got.Status, got.Code, w.status, w.code)
+		}
+	}
+	if response.Results[0].ShortUrl == "" {
+		t.Error("the saved url has no short url")
+	}
+}
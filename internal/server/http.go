@@ -1,45 +1,196 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
+	"go-url-short/internal/analytics"
+	"go-url-short/internal/auth"
+	"go-url-short/internal/policy"
+	"go-url-short/internal/ratelimit"
+	"go-url-short/internal/shorten"
 	"go-url-short/internal/store"
+	"go-url-short/internal/urlnorm"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+)
+
+const maxListLimit = 100
+
+const maxAliasLength = 64
+
+// reservedAliases are the paths of the routes that would shadow a short key
+var reservedAliases = map[string]bool{
+	"health":     true,
+	"shorten":    true,
+	"links":      true,
+	"admin":      true,
+	"workspaces": true,
+	"v1":         true,
+}
+
+// Dedup modes, sharing the key of a url shortened twice
+const (
+	DedupGlobal = "global"
+	DedupAPIKey = "apikey"
+	DedupOff    = "off"
 )
 
 type httpServer struct {
-	Log   *log.Logger
-	Store store.Store
+	Log     *log.Logger
+	Store   store.Store
+	Clicks  analytics.Sink
+	URLs    *urlnorm.Normalizer
+	Policy  *policy.Policy
+	Dedup   string
+	Limiter ratelimit.Limiter
+	Keys    *auth.Issuer
+	// Tokens verifies the bearer tokens, nil when they are disabled
+	Tokens *auth.Verifier
+	// MaxBatch is the most urls of a batch
+	MaxBatch int
+	// AuthRequired rejects the anonymous requests to the routes taking an API key
+	AuthRequired bool
+	// TrustedProxies is the number of reverse proxies appending the client address to X-Forwarded-For
+	TrustedProxies int
+	// OpenAPI is the encoded document served at /openapi.json
+	OpenAPI []byte
 }
 
-func configureStore(dbConfig *store.DatabaseConfig) store.Store {
+func configureStore(config *HTTPServerArgs) store.Store {
+	dbConfig, sqliteConfig, redisConfig, keyConfig := config.DbConfig, config.SQLite, config.Redis, config.Keys
+	if redisConfig.Addr != "" && redisConfig.Mode != store.RedisModeCache && redisConfig.Mode != store.RedisModeStore {
+		panic(fmt.Errorf("unknown redis mode: %s", redisConfig.Mode))
+	}
+
 	var st store.Store
+	switch {
+	case redisConfig.Addr != "" && redisConfig.Mode == store.RedisModeStore:
+		redisStore, err := store.NewRedisStore(redisConfig, configureKeyGenerator(keyConfig, shorten.StrategyRandom))
+		if err != nil {
+			panic(err)
+		}
+		st = redisStore
+	case dbConfig.Host != "":
+		st = store.NewPostgresStore(dbConfig, configureKeyGenerator(keyConfig, shorten.StrategySnowflake))
+	case sqliteConfig.Path != "":
+		st = store.NewSQLiteStore(sqliteConfig, configureKeyGenerator(keyConfig, shorten.StrategySnowflake))
+	default:
+		st = store.NewInMemStore(config.InMem, configureKeyGenerator(keyConfig, shorten.StrategyRandom))
+	}
 
-	if dbConfig.Host == "" {
-		st = store.NewInMemStore()
-	} else {
-		st = store.NewPostgresStore(dbConfig)
+	if redisConfig.Addr != "" && redisConfig.Mode == store.RedisModeCache {
+		st = store.NewRedisCache(st, redisConfig)
+	}
+
+	if config.Cache.Size > 0 {
+		st = store.NewLRUCache(st, config.Cache)
+	}
+
+	if config.Bloom.Capacity > 0 {
+		// The other instances sharing redis or postgres create keys the filter of this one has not seen
+		shared := (redisConfig.Addr != "" && redisConfig.Mode == store.RedisModeStore) || dbConfig.Host != ""
+		st = store.NewBloomFilter(st, config.Bloom, shared)
 	}
 	return st
 }
 
+func configureKeyGenerator(keyConfig *shorten.KeyGeneratorConfig, fallback string) shorten.KeyGenerator {
+	gen, err := shorten.NewKeyGenerator(keyConfig, fallback)
+	if err != nil {
+		panic(err)
+	}
+	return gen
+}
+
+func configureSink(dbConfig *store.DatabaseConfig, sqliteConfig *store.SQLiteConfig) analytics.Sink {
+	var sink analytics.Sink
+
+	switch {
+	case dbConfig.Host != "":
+		sink = analytics.NewPostgresSink(dbConfig)
+	case sqliteConfig.Path != "":
+		sink = analytics.NewSQLiteSink(sqliteConfig)
+	default:
+		sink = analytics.NewInMemSink()
+	}
+	return sink
+}
+
 type HTTPServerArgs struct {
-	Port     string `default:"8080" envconfig:"PORT" required:"true" desc:"Port to listen on"`
-	Host     string `default:"localhost" envconfig:"HOST" required:"true" desc:"Address to listen on"`
-	Prefix   string `default:"/" envconfig:"PREFIX" required:"true" desc:"Prefix for all routes"`
-	DbConfig *store.DatabaseConfig
+	Port     string                      `default:"8080" envconfig:"PORT" required:"true" desc:"Port to listen on"`
+	Host     string                      `default:"localhost" envconfig:"HOST" required:"true" desc:"Address to listen on"`
+	Prefix   string                      `default:"/" envconfig:"PREFIX" required:"true" desc:"Prefix for all routes"`
+	DbConfig *store.DatabaseConfig       `envconfig:"DB"`
+	Sweeper  *store.SweeperConfig        `envconfig:"SWEEP"`
+	Clicks   *analytics.BatchConfig      `envconfig:"CLICK_BATCH"`
+	Keys     *shorten.KeyGeneratorConfig `envconfig:"KEY"`
+	InMem    *store.InMemConfig          `envconfig:"INMEM"`
+	SQLite   *store.SQLiteConfig         `envconfig:"SQLITE"`
+	Redis    *store.RedisConfig          `envconfig:"REDIS"`
+	Cache    *store.CacheConfig          `envconfig:"CACHE"`
+	Bloom    *store.BloomConfig          `envconfig:"BLOOM"`
+	URL      *urlnorm.Config             `envconfig:"URL"`
+	Policy   *policy.Config              `envconfig:"POLICY"`
+	Limits   *ratelimit.Config           `envconfig:"RATELIMIT"`
+	Auth     *auth.Config                `envconfig:"AUTH"`
+	Dedup    string                      `default:"global" envconfig:"DEDUP" desc:"Share the key of a url shortened twice: global, apikey or off"`
+	MaxBatch int                         `default:"500" envconfig:"SHORTEN_MAXBATCH" desc:"Most urls a POST /shorten/batch request can shorten"`
+	Proxies  int                         `default:"0" envconfig:"TRUSTED_PROXIES" desc:"Reverse proxies appending the client address to X-Forwarded-For, 0 uses the peer address"`
 }
 
-func NewHTTPServer(config *HTTPServerArgs) *http.Server {
+// Server is the http server along with the resources it has to release on shutdown
+type Server struct {
+	*http.Server
+	clicks *analytics.Batcher
+	store  store.Store
+}
+
+// Shutdown gracefully stops the http server, then drains the buffered clicks and closes the store
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.Server.Shutdown(ctx)
+	s.clicks.Close()
+	s.store.DbClose(ctx)
+	return err
+}
+
+// Flush writes the buffered clicks, it must be called before a Lambda invocation returns
+// as the buffer is frozen along with the execution environment in between invocations
+func (s *Server) Flush(ctx context.Context) error {
+	return s.clicks.Flush(ctx)
+}
+
+func NewHTTPServer(config *HTTPServerArgs) *Server {
 	httpLog := log.New(log.Writer(), "HTTPSERVER:", log.LstdFlags)
+	clicks := analytics.NewBatcher(configureSink(config.DbConfig, config.SQLite), config.Clicks)
 	s := &httpServer{
-		Log:   httpLog,
-		Store: configureStore(config.DbConfig),
+		Log:            httpLog,
+		Store:          configureStore(config),
+		Clicks:         clicks,
+		URLs:           urlnorm.NewNormalizer(config.URL),
+		Policy:         policy.NewPolicy(config.Policy),
+		Dedup:          config.Dedup,
+		Limiter:        ratelimit.NewLimiter(config.Limits, config.Redis),
+		MaxBatch:       config.MaxBatch,
+		TrustedProxies: config.Proxies,
+	}
+	s.Keys, s.AuthRequired = auth.NewIssuer(s.Store, config.Auth), config.Auth.Required
+	if config.Auth.JWT.JWKS != "" {
+		s.Tokens = auth.NewVerifier(config.Auth.JWT)
+	}
+	if s.Dedup != DedupGlobal && s.Dedup != DedupAPIKey && s.Dedup != DedupOff {
+		panic(fmt.Errorf("unknown dedup mode: %s", s.Dedup))
+	}
+	if s.MaxBatch <= 0 {
+		panic(fmt.Errorf("max batch must be positive: %d", s.MaxBatch))
 	}
+	s.OpenAPI = mustMarshal(s.openAPIDocument(config.Auth.JWT.Groups))
+	stopSweeper := store.StartSweeper(s.Store, config.Sweeper)
 
 	r := mux.NewRouter()
 	r.Use(func(next http.Handler) http.Handler {
@@ -50,94 +201,461 @@ func NewHTTPServer(config *HTTPServerArgs) *http.Server {
 	})
 	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		s.Log.Println("Method not allowed", r.RequestURI)
+		if versioned(r) {
+			writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" Method not allowed")
+			return
+		}
 		http.Error(w, fmt.Sprintf("Method not allowed: %s", r.Method), http.StatusMethodNotAllowed)
 	})
 	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		s.Log.Println("Not found", r.RequestURI)
+		if versioned(r) {
+			writeError(w, r, http.StatusNotFound, CodeNotFound, "Not found: "+r.URL.Path)
+			return
+		}
 		http.Error(w, fmt.Sprintf("Not found: %s", r.RequestURI), http.StatusNotFound)
 	})
 
 	r.HandleFunc("/health", s.handleHealthCheck).Methods("GET")
-	r.HandleFunc("/shorten", s.handleShorten).Methods("POST")
-	r.HandleFunc("/{shortURL}", s.handleRedirect)
-	return &http.Server{
+	r.HandleFunc("/openapi.json", s.handleOpenAPI).Methods("GET")
+
+	// The /v1 API answers in JSON with the error envelope, the same routes without prefix keep their former errors
+	v1 := r.PathPrefix(apiPrefix).Subrouter()
+	v1.Use(s.negotiate)
+	s.routes(v1, config)
+	s.routes(r, config)
+
+	r.HandleFunc("/{shortURL}", s.rateLimit("redirect", config.Limits.Redirect, s.handleRedirect))
+	srv := &http.Server{
 		Addr:    strings.Join([]string{config.Host, ":", config.Port}, ""),
 		Handler: r,
 	}
+	srv.RegisterOnShutdown(stopSweeper)
+	srv.RegisterOnShutdown(s.Policy.Close)
+	if s.Tokens != nil {
+		srv.RegisterOnShutdown(s.Tokens.Close)
+	}
+	return &Server{
+		Server: srv,
+		clicks: clicks,
+		store:  s.Store,
+	}
+}
+
+// routes registers the operations of the admin and api groups on the router, both may accept bearer tokens
+func (s *httpServer) routes(r *mux.Router, config *HTTPServerArgs) {
+	limits := map[string]ratelimit.Limit{
+		"admin":   config.Limits.Links,
+		"shorten": config.Limits.Shorten,
+		"batch":   config.Limits.Batch,
+		"links":   config.Limits.Links,
+	}
+	groups := map[string]*mux.Router{
+		auth.GroupAdmin: r.NewRoute().Subrouter(),
+		auth.GroupAPI:   r.NewRoute().Subrouter(),
+	}
+	for _, op := range operations {
+		handler := op.handler
+		groups[op.group].HandleFunc(op.path, s.authenticate(op.scope, s.rateLimit(op.bucket, limits[op.bucket], func(w http.ResponseWriter, r *http.Request) {
+			handler(s, w, r)
+		}))).Methods(op.method)
+	}
+
+	if s.Tokens != nil {
+		for _, group := range config.Auth.JWT.Groups {
+			router, ok := groups[group]
+			if !ok {
+				panic(fmt.Errorf("unknown route group: %s", group))
+			}
+			router.Use(s.bearer)
+		}
+	}
 }
 
 func (s *httpServer) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(&HealthResponse{"ok, I'm healthy"})
 }
 
 func (s *httpServer) handleShorten(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(&ErrorResponse{r.Method + " Method not allowed"})
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" Method not allowed")
+		return
+	}
+
+	var req ShortenRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeDecodeError(w, r, err)
 		return
 	}
 
-	originalURL := r.FormValue("url")
+	item, linkErr := s.newLink(r, req)
+	if linkErr != nil && linkErr.url != "" {
+		writeInvalidURL(w, r, &InvalidURLResponse{Error: linkErr.message, Code: linkErr.code, Url: linkErr.url})
+		return
+	}
+	if linkErr != nil {
+		writeError(w, r, linkErr.status, linkErr.code, linkErr.message)
+		return
+	}
+	originalURL, alias, expiresAt := item.URL, item.Opts.Alias, item.Opts.ExpiresAt
+
+	shortKey, err := s.Store.Set(r.Context(), originalURL, item.Opts)
+	if err != nil && errors.Is(err, store.ErrKeyAlreadyExists) {
+		writeError(w, r, http.StatusConflict, CodeAliasTaken, "Already exists key("+alias+")")
+		return
+	}
+
+	if err != nil && errors.Is(err, store.ErrKeyGenerationFailed) {
+		writeError(w, r, http.StatusServiceUnavailable, CodeKeyGenerationFailed, "No free key found, retry later")
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	result := shortURL(r, shortKey)
+	s.Log.Printf("Generated short url %s from %s", result, originalURL)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&ShortUrlResponse{
+		ShortUrl:  result,
+		Url:       originalURL,
+		ExpiresAt: timeOrNil(expiresAt),
+	})
+}
+
+// linkError is why a url cannot be shortened, url is set for the urls rejected by the normalizer
+type linkError struct {
+	status  int
+	message string
+	code    string
+	url     string
+}
+
+// newLink validates the url and the options of a link to create, the url is normalized
+func (s *httpServer) newLink(r *http.Request, req ShortenRequest) (store.BatchItem, *linkError) {
+	if req.Url == "" {
+		return store.BatchItem{}, &linkError{status: http.StatusBadRequest, message: "Missing original url parmas", code: CodeMissingParameter}
+	}
+
+	normalizedURL, err := s.URLs.Normalize(req.Url)
+	if err != nil {
+		invalid := invalidURLResponse(req.Url, err)
+		return store.BatchItem{}, &linkError{status: http.StatusBadRequest, message: invalid.Error, code: invalid.Code, url: req.Url}
+	}
+
+	if err := s.Policy.Check(normalizedURL); err != nil {
+		return store.BatchItem{}, &linkError{status: http.StatusForbidden, message: err.Error(), code: CodeBlockedDestination}
+	}
+
+	if req.Alias != "" {
+		if err := validateAlias(req.Alias); err != nil {
+			return store.BatchItem{}, &linkError{status: http.StatusBadRequest, message: err.Error(), code: CodeInvalidAlias}
+		}
+	}
+
+	expiry, err := parseExpiry(req.ExpiresAt, req.Ttl)
+	if err != nil {
+		return store.BatchItem{}, &linkError{status: http.StatusBadRequest, message: err.Error(), code: CodeInvalidExpiry}
+	}
+
+	return store.BatchItem{URL: normalizedURL, Opts: store.SetOptions{
+		Alias:      req.Alias,
+		ExpiresAt:  expiry,
+		DedupScope: s.dedupScope(r),
+		Owner:      linkOwner(r),
+	}}, nil
+}
+
+func (s *httpServer) handleUpdateLink(w http.ResponseWriter, r *http.Request) {
+	shortKey := mux.Vars(r)["key"]
+	var req UpdateLinkRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	originalURL := req.Url
 	if originalURL == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(&ErrorResponse{"Missing original url parmas"})
+		writeError(w, r, http.StatusBadRequest, CodeMissingParameter, "Missing original url parmas")
 		return
 	}
 
-	shortKey, err := s.Store.Set(originalURL)
+	normalizedURL, err := s.URLs.Normalize(originalURL)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(&ErrorResponse{"Unhandled Error"})
+		writeInvalidURL(w, r, invalidURLResponse(originalURL, err))
 		return
 	}
+	originalURL = normalizedURL
 
-	// TODO: Delete Hardcoded URL
-	host := r.Host
-	if r.TLS != nil {
-		host = "https://" + host
-	} else {
-		host = "http://" + host
+	if err := s.Policy.Check(originalURL); err != nil {
+		writeError(w, r, http.StatusForbidden, CodeBlockedDestination, err.Error())
+		return
 	}
-	result := fmt.Sprintf("%s/%s", host, shortKey)
-	s.Log.Printf("Generated short url %s form ", result, originalURL)
-	w.WriteHeader(http.StatusCreated)
+
+	if !s.checkOwner(w, r, shortKey) {
+		return
+	}
+
+	err = s.Store.Update(r.Context(), shortKey, originalURL)
+	if err != nil && errors.Is(err, store.ErrKeyNotFound) {
+		writeError(w, r, http.StatusNotFound, CodeLinkNotFound, "Not Found key("+shortKey+")")
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	s.Log.Printf("Updated key(%s) to %s", shortKey, originalURL)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(&ShortUrlResponse{
-		ShortUrl: result,
+		ShortUrl: shortURL(r, shortKey),
 		Url:      originalURL,
 	})
 }
 
+func (s *httpServer) handleDeleteLink(w http.ResponseWriter, r *http.Request) {
+	shortKey := mux.Vars(r)["key"]
+	if !s.checkOwner(w, r, shortKey) {
+		return
+	}
+
+	err := s.Store.Delete(r.Context(), shortKey)
+	if err != nil && errors.Is(err, store.ErrKeyNotFound) {
+		writeError(w, r, http.StatusNotFound, CodeLinkNotFound, "Not Found key("+shortKey+")")
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	s.Log.Printf("Deleted key(%s)", shortKey)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *httpServer) handleListLinks(w http.ResponseWriter, r *http.Request) {
+	cursor := r.FormValue("cursor")
+	limit := store.DefaultListLimit
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxListLimit {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidLimit, fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+			return
+		}
+		limit = n
+	}
+
+	// Admins see every link, the other callers the links of their workspace and anonymous callers none
+	caller, _ := auth.CallerFrom(r.Context())
+	if !caller.Admin() && caller.Workspace() == "" {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Listing links requires the API key of a workspace")
+		return
+	}
+
+	var links []store.Link
+	var next string
+	var err error
+	if caller.Admin() {
+		links, next, err = s.Store.List(r.Context(), cursor, limit)
+	} else {
+		links, next, err = s.Store.ListByOwner(r.Context(), caller.Workspace(), cursor, limit)
+	}
+	if err != nil && errors.Is(err, store.ErrInvalidCursor) {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidCursor, "Invalid cursor("+cursor+")")
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	result := make([]LinkResponse, 0, len(links))
+	for _, link := range links {
+		result = append(result, LinkResponse{
+			Key:       link.Key,
+			ShortUrl:  shortURL(r, link.Key),
+			Url:       link.URL,
+			CreatedAt: link.CreatedAt,
+			ExpiresAt: timeOrNil(link.ExpiresAt),
+			Owner:     link.Owner,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&ListLinksResponse{
+		Links:      result,
+		NextCursor: next,
+	})
+}
+
+func (s *httpServer) handleLinkStats(w http.ResponseWriter, r *http.Request) {
+	shortKey := mux.Vars(r)["key"]
+
+	// Expired links still have stats until they are purged.
+	// The links of other workspaces are reported missing so that their keys are not disclosed.
+	link, err := s.Store.GetLink(r.Context(), shortKey)
+	caller, _ := auth.CallerFrom(r.Context())
+	if (err != nil && errors.Is(err, store.ErrKeyNotFound)) || (err == nil && !caller.CanSee(link.Owner)) {
+		writeError(w, r, http.StatusNotFound, CodeLinkNotFound, "Not Found key("+shortKey+")")
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	stats, err := s.Clicks.Stats(r.Context(), shortKey)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	daily := make([]DailyCountResponse, 0, len(stats.Daily))
+	for _, d := range stats.Daily {
+		daily = append(daily, DailyCountResponse{Date: d.Date, Count: d.Count})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&StatsResponse{
+		Key:   shortKey,
+		Total: stats.Total,
+		Daily: daily,
+	})
+}
+
 func (s *httpServer) handleRedirect(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	shortURL := params["shortURL"]
 	if shortURL == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(&ErrorResponse{"Missing short url"})
+		writeError(w, r, http.StatusBadRequest, CodeMissingParameter, "Missing short url")
+		return
+	}
+
+	var originalURL string
+	err := store.ErrKeyNotFound
+	// Short keys are base62 only, paths such as /wp-admin cannot exist and never reach the store
+	if len(shortURL) <= maxAliasLength && shorten.IsBase62(shortURL) {
+		originalURL, err = s.Store.Get(r.Context(), shortURL)
+	}
+	if err != nil && errors.Is(err, store.ErrKeyNotFound) {
+		writeError(w, r, http.StatusNotFound, CodeLinkNotFound, "Not Found key("+shortURL+")")
 		return
 	}
 
-	originalURL, err := s.Store.Get(shortURL)
-	if err != nil && errors.Is(store.ErrKeyNotFound, err) {
-		w.WriteHeader(http.StatusNotFound)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(&ErrorResponse{"Not Found key(" + shortURL + ")"})
+	if err != nil && errors.Is(err, store.ErrKeyExpired) {
+		writeError(w, r, http.StatusGone, CodeLinkExpired, "Expired key("+shortURL+")")
 		return
 	}
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(&ErrorResponse{"Unhandled Error"})
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	// The rules may have changed since the link was created
+	if err := s.Policy.Check(originalURL); err != nil {
+		s.Log.Printf("Refusing to redirect key(%s): %v", shortURL, err)
+		writeError(w, r, http.StatusForbidden, CodeBlockedDestination, err.Error())
 		return
 	}
 
 	s.Log.Printf("Redirecting key(%s) to %s", shortURL, originalURL)
-	http.Redirect(w, r, originalURL, http.StatusPermanentRedirect)
+	err = s.Clicks.Record(r.Context(), analytics.Click{
+		Key:       shortURL,
+		Time:      time.Now(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IP:        analytics.AnonymizeIP(analytics.ClientIP(r, s.TrustedProxies)),
+	})
+	if err != nil {
+		s.Log.Printf("Error recording click of key(%s): %v", shortURL, err)
+	}
+	// A permanent redirect would be cached by the clients, whose later clicks would never be recorded
+	// nor follow the link once it is updated, deleted or expired
+	http.Redirect(w, r, originalURL, http.StatusFound)
+}
+
+// shortURL builds the public short url for the key from the request host
+func shortURL(r *http.Request, shortKey string) string {
+	// TODO: Delete Hardcoded URL
+	host := r.Host
+	if r.TLS != nil {
+		host = "https://" + host
+	} else {
+		host = "http://" + host
+	}
+	return fmt.Sprintf("%s/%s", host, shortKey)
+}
+
+// validateAlias checks a custom alias can be used as a short key
+func validateAlias(alias string) error {
+	if len(alias) > maxAliasLength {
+		return fmt.Errorf("alias must be at most %d characters", maxAliasLength)
+	}
+	if !shorten.IsBase62(alias) {
+		return fmt.Errorf("alias must only contain the characters [0-9a-zA-Z]")
+	}
+	if reservedAliases[alias] {
+		return fmt.Errorf("alias(%s) is reserved", alias)
+	}
+	return nil
+}
+
+// dedupScope is the scope the urls of the request are shared in, anonymous requests share the scope of the empty API key.
+// The links are only shared with their own owner within the scope, the anonymous links among themselves.
+func (s *httpServer) dedupScope(r *http.Request) string {
+	switch s.Dedup {
+	case DedupGlobal:
+		return DedupGlobal
+	case DedupAPIKey:
+		caller, _ := auth.CallerFrom(r.Context())
+		return DedupAPIKey + ":" + caller.Key.ID
+	}
+	return ""
+}
+
+// parseExpiry reads the optional expiry of a new link from either an absolute
+// expires_at (RFC 3339) or a relative ttl (Go duration such as 72h)
+func parseExpiry(expiresAt string, ttl string) (time.Time, error) {
+	switch {
+	case expiresAt != "" && ttl != "":
+		return time.Time{}, errors.New("only one of expires_at and ttl can be given")
+	case expiresAt != "":
+		t, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("expires_at must be an RFC 3339 time: %s", expiresAt)
+		}
+		if !t.After(time.Now()) {
+			return time.Time{}, fmt.Errorf("expires_at must be in the future: %s", expiresAt)
+		}
+		return t, nil
+	case ttl != "":
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("ttl must be a positive duration such as 72h: %s", ttl)
+		}
+		return time.Now().Add(d), nil
+	}
+	return time.Time{}, nil
+}
+
+// timeOrNil omits the zero time from responses
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
 }
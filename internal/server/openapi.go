@@ -0,0 +1,301 @@
+package server
+
+import (
+	"encoding/json"
+	"go-url-short/internal/auth"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// operation is a route of the api or admin group along with its description in the OpenAPI document.
+// routes registers the handlers of the operations, so the document cannot miss a route of the groups.
+type operation struct {
+	group   string
+	method  string
+	path    string
+	summary string
+	scope   string
+	// bucket is the rate limit bucket of the route
+	bucket  string
+	handler func(*httpServer, http.ResponseWriter, *http.Request)
+	// request is the type of the body, nil for the routes without one
+	request any
+	// query are the names of the query params, all optional strings but limit
+	query  []string
+	status int
+	// response is the type of the body of status, nil for the responses without one
+	response any
+	// errors are the error statuses of the operation besides the ones every operation may answer
+	errors []int
+}
+
+var operations = []operation{
+	{group: auth.GroupAdmin, method: "POST", path: "/admin/keys", summary: "Issue an API key, its secret is only returned once", scope: auth.ScopeAdmin, bucket: "admin",
+		handler: (*httpServer).handleIssueKey, request: IssueKeyRequest{}, status: http.StatusCreated, response: APIKeyResponse{}},
+	{group: auth.GroupAdmin, method: "GET", path: "/admin/keys", summary: "List the API keys", scope: auth.ScopeAdmin, bucket: "admin",
+		handler: (*httpServer).handleListKeys, status: http.StatusOK, response: ListAPIKeysResponse{}},
+	{group: auth.GroupAdmin, method: "DELETE", path: "/admin/keys/{id}", summary: "Revoke an API key", scope: auth.ScopeAdmin, bucket: "admin",
+		handler: (*httpServer).handleRevokeKey, status: http.StatusNoContent, errors: []int{http.StatusNotFound}},
+	{group: auth.GroupAdmin, method: "POST", path: "/admin/users", summary: "Create a user", scope: auth.ScopeAdmin, bucket: "admin",
+		handler: (*httpServer).handleCreateUser, request: CreateUserRequest{}, status: http.StatusCreated, response: UserResponse{}},
+	{group: auth.GroupAdmin, method: "GET", path: "/admin/users", summary: "List the users", scope: auth.ScopeAdmin, bucket: "admin",
+		handler: (*httpServer).handleListUsers, status: http.StatusOK, response: ListUsersResponse{}},
+	{group: auth.GroupAdmin, method: "POST", path: "/admin/workspaces", summary: "Create a workspace with the user of owner as its first owner", scope: auth.ScopeAdmin, bucket: "admin",
+		handler: (*httpServer).handleCreateWorkspace, request: CreateWorkspaceRequest{}, status: http.StatusCreated, response: WorkspaceResponse{}},
+	{group: auth.GroupAdmin, method: "GET", path: "/admin/workspaces", summary: "List the workspaces", scope: auth.ScopeAdmin, bucket: "admin",
+		handler: (*httpServer).handleListWorkspaces, status: http.StatusOK, response: ListWorkspacesResponse{}},
+
+	{group: auth.GroupAPI, method: "POST", path: "/shorten", summary: "Shorten a url", scope: auth.ScopeCreate, bucket: "shorten",
+		handler: (*httpServer).handleShorten, request: ShortenRequest{}, status: http.StatusCreated, response: ShortUrlResponse{},
+		errors: []int{http.StatusConflict, http.StatusServiceUnavailable}},
+	{group: auth.GroupAPI, method: "POST", path: "/shorten/batch", summary: "Shorten a batch of urls, each url gets its own status", scope: auth.ScopeCreate, bucket: "batch",
+		handler: (*httpServer).handleShortenBatch, request: []ShortenRequest{}, status: http.StatusOK, response: BatchResponse{},
+		errors: []int{http.StatusRequestEntityTooLarge}},
+	{group: auth.GroupAPI, method: "GET", path: "/links", summary: "List the links, newest first", scope: auth.ScopeReadStats, bucket: "links",
+		handler: (*httpServer).handleListLinks, query: []string{"cursor", "limit"}, status: http.StatusOK, response: ListLinksResponse{},
+		errors: []int{http.StatusBadRequest}},
+	{group: auth.GroupAPI, method: "PUT", path: "/links/{key}", summary: "Change the destination of a link", scope: auth.ScopeCreate, bucket: "links",
+		handler: (*httpServer).handleUpdateLink, request: UpdateLinkRequest{}, status: http.StatusOK, response: ShortUrlResponse{},
+		errors: []int{http.StatusNotFound}},
+	{group: auth.GroupAPI, method: "DELETE", path: "/links/{key}", summary: "Delete a link", scope: auth.ScopeCreate, bucket: "links",
+		handler: (*httpServer).handleDeleteLink, status: http.StatusNoContent, errors: []int{http.StatusNotFound}},
+	{group: auth.GroupAPI, method: "GET", path: "/links/{key}/stats", summary: "Clicks of a link, in total and per UTC day", scope: auth.ScopeReadStats, bucket: "links",
+		handler: (*httpServer).handleLinkStats, status: http.StatusOK, response: StatsResponse{}, errors: []int{http.StatusNotFound}},
+	{group: auth.GroupAPI, method: "GET", path: "/workspaces/{id}/members", summary: "List the members of a workspace", scope: auth.ScopeReadStats, bucket: "links",
+		handler: (*httpServer).handleListMembers, status: http.StatusOK, response: ListMembersResponse{}, errors: []int{http.StatusNotFound}},
+	{group: auth.GroupAPI, method: "PUT", path: "/workspaces/{id}/members/{user}", summary: "Add a member to a workspace or change its role", scope: auth.ScopeCreate, bucket: "links",
+		handler: (*httpServer).handleSetMember, request: SetMemberRequest{}, status: http.StatusOK, response: MemberResponse{},
+		errors: []int{http.StatusNotFound, http.StatusConflict}},
+	{group: auth.GroupAPI, method: "DELETE", path: "/workspaces/{id}/members/{user}", summary: "Remove a member from a workspace", scope: auth.ScopeCreate, bucket: "links",
+		handler: (*httpServer).handleRemoveMember, status: http.StatusNoContent, errors: []int{http.StatusNotFound, http.StatusConflict}},
+}
+
+// commonErrors may be answered by every operation, the authentication, negotiation and rate limiting errors
+var commonErrors = []int{
+	http.StatusUnauthorized,
+	http.StatusForbidden,
+	http.StatusNotAcceptable,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+}
+
+// bodyErrors may be answered by every operation taking a body
+var bodyErrors = []int{
+	http.StatusBadRequest,
+	http.StatusUnsupportedMediaType,
+}
+
+func (s *httpServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(s.OpenAPI)
+}
+
+// openAPIDocument describes the /v1 operations, the health check and the redirects in an OpenAPI 3 document
+func (s *httpServer) openAPIDocument(jwtGroups []string) map[string]any {
+	c := components{}
+	paths := map[string]map[string]any{}
+	for _, op := range operations {
+		security := []map[string][]string{{"apiKey": {}}}
+		for _, group := range jwtGroups {
+			if s.Tokens != nil && group == op.group {
+				security = append(security, map[string][]string{"bearer": {}})
+			}
+		}
+		if !s.AuthRequired && op.scope != auth.ScopeAdmin {
+			security = append(security, map[string][]string{})
+		}
+
+		errs := append(append([]int{}, op.errors...), commonErrors...)
+		if op.request != nil {
+			errs = append(errs, bodyErrors...)
+		}
+		responses := map[string]any{strconv.Itoa(op.status): c.response(op.status, op.response)}
+		for _, status := range errs {
+			responses[strconv.Itoa(status)] = c.response(status, ErrorEnvelope{})
+		}
+
+		o := map[string]any{
+			"operationId": operationID(op.method, op.path),
+			"summary":     op.summary,
+			"description": "Requires the " + op.scope + " scope",
+			"tags":        []string{op.group},
+			"security":    security,
+			"responses":   responses,
+		}
+		if params := parameters(op.path, op.query); len(params) > 0 {
+			o["parameters"] = params
+		}
+		if op.request != nil {
+			content := map[string]any{"application/json": map[string]any{"schema": c.schema(reflect.TypeOf(op.request))}}
+			if reflect.TypeOf(op.request).Kind() == reflect.Struct {
+				content["application/x-www-form-urlencoded"] = map[string]any{"schema": c.schema(reflect.TypeOf(op.request))}
+			}
+			o["requestBody"] = map[string]any{"required": true, "content": content}
+		}
+
+		path := apiPrefix + op.path
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(op.method)] = o
+	}
+
+	// The routes outside of the groups answer errors without the envelope
+	paths["/health"] = map[string]any{"get": map[string]any{
+		"operationId": "getHealth",
+		"summary":     "Health check",
+		"responses":   map[string]any{"200": c.response(http.StatusOK, HealthResponse{})},
+	}}
+	paths["/openapi.json"] = map[string]any{"get": map[string]any{
+		"operationId": "getOpenAPI",
+		"summary":     "This document",
+		"responses": map[string]any{"200": map[string]any{
+			"description": http.StatusText(http.StatusOK),
+			"content":     map[string]any{"application/json": map[string]any{"schema": map[string]any{"type": "object"}}},
+		}},
+	}}
+	paths["/{shortURL}"] = map[string]any{"get": map[string]any{
+		"operationId": "redirect",
+		"summary":     "Redirect to the url of a link",
+		"parameters":  parameters("/{shortURL}", nil),
+		"responses": map[string]any{
+			"302": map[string]any{
+				"description": http.StatusText(http.StatusFound),
+				"headers":     map[string]any{"Location": map[string]any{"schema": map[string]any{"type": "string"}}},
+			},
+			"400": c.response(http.StatusBadRequest, ErrorResponse{}),
+			"403": c.response(http.StatusForbidden, ErrorResponse{}),
+			"404": c.response(http.StatusNotFound, ErrorResponse{}),
+			"410": c.response(http.StatusGone, ErrorResponse{}),
+			"429": c.response(http.StatusTooManyRequests, ErrorResponse{}),
+			"500": c.response(http.StatusInternalServerError, ErrorResponse{}),
+		},
+	}}
+
+	schemes := map[string]any{"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"}}
+	if s.Tokens != nil {
+		schemes["bearer"] = map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"}
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "go-url-short",
+			"version":     strings.TrimPrefix(apiPrefix, "/"),
+			"description": "The routes are also served without the " + apiPrefix + " prefix, answering errors as {\"error\": message} instead",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": c, "securitySchemes": schemes},
+	}
+}
+
+// operationID names the operation after its method and path, such as putLinksKey
+func operationID(method string, path string) string {
+	id := strings.ToLower(method)
+	for _, segment := range strings.Split(path, "/") {
+		segment = strings.Trim(segment, "{}")
+		if segment != "" {
+			id += strings.ToUpper(segment[:1]) + segment[1:]
+		}
+	}
+	return id
+}
+
+// parameters describes the path params of the mux template and the query params
+func parameters(path string, query []string) []map[string]any {
+	var params []map[string]any
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, map[string]any{"name": strings.Trim(segment, "{}"), "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		}
+	}
+	for _, name := range query {
+		schema := map[string]any{"type": "string"}
+		if name == "limit" {
+			schema = map[string]any{"type": "integer", "minimum": 1, "maximum": maxListLimit}
+		}
+		params = append(params, map[string]any{"name": name, "in": "query", "schema": schema})
+	}
+	return params
+}
+
+// components are the schemas of the request and response types, by type name
+type components map[string]any
+
+// response describes a response with a JSON body of the type of body, or without body when it is nil
+func (c components) response(status int, body any) map[string]any {
+	response := map[string]any{"description": http.StatusText(status)}
+	if body != nil {
+		response["content"] = map[string]any{"application/json": map[string]any{"schema": c.schema(reflect.TypeOf(body))}}
+	}
+	return response
+}
+
+// schema returns the JSON schema of the type as encoding/json writes it, the structs are referenced from the components.
+// The fields with omitempty are the optional ones.
+func (c components) schema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": c.schema(t.Elem())}
+	case reflect.Pointer:
+		schema := c.schema(t.Elem())
+		if _, ok := schema["$ref"]; ok {
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return map[string]any{"type": "string", "format": "date-time"}
+		}
+		if _, ok := c[t.Name()]; !ok {
+			// Set first so that a recursive type references itself
+			c[t.Name()] = nil
+			properties := map[string]any{}
+			var required []string
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+				if name == "-" || !field.IsExported() {
+					continue
+				}
+				if name == "" {
+					name = field.Name
+				}
+				properties[name] = c.schema(field.Type)
+				if options != "omitempty" {
+					required = append(required, name)
+				}
+			}
+			schema := map[string]any{"type": "object", "properties": properties}
+			if len(required) > 0 {
+				sort.Strings(required)
+				schema["required"] = required
+			}
+			c[t.Name()] = schema
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	panic("no schema for type " + t.String())
+}
+
+// mustMarshal encodes the document once for all the requests to /openapi.json
+func mustMarshal(document any) []byte {
+	b, err := json.Marshal(document)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,409 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/kelseyhightower/envconfig"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestOpenAPI checks the document served at /openapi.json against a server running on the in-memory store:
+// each route of the router must be documented, each documented operation is called
+// and the status and body of every response must be described by the document.
+func TestOpenAPI(t *testing.T) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	adminKey := hex.EncodeToString(secret)
+
+	// The check only relies on the defaults, whatever the environment says
+	environ := os.Environ()
+	os.Clearenv()
+	t.Cleanup(func() {
+		os.Clearenv()
+		for _, kv := range environ {
+			name, value, _ := strings.Cut(kv, "=")
+			os.Setenv(name, value)
+		}
+	})
+	env := map[string]string{
+		"AUTH_ADMINKEY":      adminKey,
+		"RATELIMIT_SHORTEN":  "0",
+		"RATELIMIT_BATCH":    "0",
+		"RATELIMIT_REDIRECT": "0",
+		"RATELIMIT_LINKS":    "0",
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+	var args HTTPServerArgs
+	if err := envconfig.Process("", &args); err != nil {
+		t.Fatal(err)
+	}
+	if !testing.Verbose() {
+		// The server logs every request
+		output := log.Writer()
+		log.SetOutput(io.Discard)
+		t.Cleanup(func() { log.SetOutput(output) })
+	}
+
+	srv := NewHTTPServer(&args)
+	defer srv.Shutdown(context.Background())
+	if err := checkOpenAPI(srv, adminKey); err != nil {
+		t.Error(err)
+	}
+}
+
+// checkOpenAPI returns every drift between the document and the server joined, or nil when they agree.
+// adminKey is the AUTH_ADMINKEY of the server, the rate limits of the server must allow the few requests of the check.
+func checkOpenAPI(srv *Server, adminKey string) error {
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	c := &openAPIChecker{
+		base:      ts.URL,
+		adminKey:  adminKey,
+		client:    &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }},
+		exercised: map[string]bool{},
+	}
+	resp, err := c.client.Get(ts.URL + "/openapi.json")
+	if err != nil {
+		return fmt.Errorf("GET /openapi.json: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&c.document); err != nil {
+		return fmt.Errorf("GET /openapi.json: %w", err)
+	}
+
+	router, ok := srv.Handler.(*mux.Router)
+	if !ok {
+		return errors.New("the handler of the server is not a router")
+	}
+	c.checkRoutes(router)
+	c.run()
+	for _, id := range c.operations() {
+		if !c.exercised[id] {
+			c.fail("%s: never called by the check", id)
+		}
+	}
+	return errors.Join(c.errs...)
+}
+
+type openAPIChecker struct {
+	base     string
+	adminKey string
+	client   *http.Client
+	document map[string]any
+	// exercised are the "METHOD path" of the documented operations called so far
+	exercised map[string]bool
+	errs      []error
+}
+
+func (c *openAPIChecker) fail(format string, args ...any) {
+	c.errs = append(c.errs, fmt.Errorf(format, args...))
+}
+
+// operations are the "METHOD path" of the documented operations
+func (c *openAPIChecker) operations() []string {
+	var ids []string
+	paths, _ := c.document["paths"].(map[string]any)
+	for path, item := range paths {
+		methods, _ := item.(map[string]any)
+		for method := range methods {
+			ids = append(ids, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// checkRoutes checks that the routes of the router are documented, the routes without prefix through their /v1 route
+func (c *openAPIChecker) checkRoutes(router *mux.Router) {
+	documented := map[string]bool{}
+	for _, id := range c.operations() {
+		documented[id] = true
+	}
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil
+		}
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// The routes for every method, the redirects, are documented as GET
+			methods = []string{http.MethodGet}
+		}
+		for _, method := range methods {
+			if !documented[method+" "+path] && !documented[method+" "+apiPrefix+path] {
+				c.fail("%s %s: route missing from the document", method, path)
+			}
+		}
+		return nil
+	})
+}
+
+// run calls every documented operation, with some of their error responses
+func (c *openAPIChecker) run() {
+	admin := map[string]string{"X-API-Key": c.adminKey}
+	form := map[string]string{"X-API-Key": c.adminKey, "Content-Type": "application/x-www-form-urlencoded"}
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	c.call("GET", "/health", nil, "")
+	c.call("GET", "/openapi.json", nil, "")
+
+	user, _ := c.call("POST", "/v1/admin/users", admin, `{"name":"openapi"}`)["id"].(string)
+	other, _ := c.call("POST", "/v1/admin/users", form, "name=other")["id"].(string)
+	c.call("POST", "/v1/admin/users", admin, `{}`)
+	c.call("GET", "/v1/admin/users", admin, "")
+	workspace, _ := c.call("POST", "/v1/admin/workspaces", admin, `{"name":"openapi","owner":"`+user+`"}`)["id"].(string)
+	c.call("POST", "/v1/admin/workspaces", admin, `{"name":"openapi","owner":"nobody"}`)
+	c.call("GET", "/v1/admin/workspaces", admin, "")
+
+	c.call("PUT", "/v1/workspaces/"+workspace+"/members/"+other, admin, `{"role":"editor"}`)
+	c.call("PUT", "/v1/workspaces/"+workspace+"/members/"+other, admin, `{"role":"superuser"}`)
+	c.call("GET", "/v1/workspaces/"+workspace+"/members", admin, "")
+	c.call("GET", "/v1/workspaces/nowhere/members", admin, "")
+	c.call("DELETE", "/v1/workspaces/"+workspace+"/members/"+user, admin, "")
+	c.call("DELETE", "/v1/workspaces/"+workspace+"/members/"+other, admin, "")
+	c.call("DELETE", "/v1/workspaces/"+workspace+"/members/"+other, admin, "")
+
+	key := c.call("POST", "/v1/admin/keys", admin, `{"owner":"`+workspace+`","user":"`+user+`","scopes":["create","read-stats"]}`)
+	secret, _ := key["key"].(string)
+	id, _ := key["id"].(string)
+	c.call("POST", "/v1/admin/keys", form, "owner=openapi&scopes=create,nope")
+	c.call("GET", "/v1/admin/keys", admin, "")
+
+	member := map[string]string{"X-API-Key": secret}
+	alias := "openapi" + suffix
+	c.call("POST", "/v1/shorten", member, `{"url":"https://example.com/openapi","alias":"`+alias+`","ttl":"24h"}`)
+	c.call("POST", "/v1/shorten", member, `{"url":"https://example.com/openapi","alias":"`+alias+`"}`)
+	c.call("POST", "/v1/shorten", member, `{"url":"ftp://example.com"}`)
+	c.call("POST", "/v1/shorten", map[string]string{"Content-Type": "text/plain"}, "https://example.com")
+	c.call("POST", "/v1/shorten", map[string]string{"Accept": "text/html"}, `{"url":"https://example.com"}`)
+	c.call("POST", "/v1/shorten", map[string]string{"X-API-Key": "invalid"}, `{"url":"https://example.com"}`)
+	c.call("POST", "/v1/shorten/batch", member, `[{"url":"https://example.com/openapi/batch"},{"url":"ftp://example.com"},{"url":"https://example.com","alias":"`+alias+`"}]`)
+	c.call("POST", "/v1/shorten/batch", member, `[]`)
+	c.call("GET", "/v1/links?limit=1", member, "")
+	c.call("GET", "/v1/links?limit=0", member, "")
+	c.call("PUT", "/v1/links/"+alias, member, `{"url":"https://example.com/openapi/updated"}`)
+	c.call("PUT", "/v1/links/nothing"+suffix, member, `{"url":"https://example.com"}`)
+	c.call("GET", "/v1/links/"+alias+"/stats", member, "")
+	c.call("GET", "/v1/links/nothing"+suffix+"/stats", member, "")
+	c.call("GET", "/"+alias, nil, "")
+	c.call("GET", "/nothing"+suffix, nil, "")
+	c.call("DELETE", "/v1/links/"+alias, member, "")
+	c.call("DELETE", "/v1/links/"+alias, member, "")
+
+	c.call("DELETE", "/v1/admin/keys/"+id, admin, "")
+	c.call("DELETE", "/v1/admin/keys/"+id+"x", admin, "")
+	c.call("GET", "/v1/links", member, "")
+}
+
+// call makes the request and checks its response against the document, it returns the body when it is a JSON object.
+// The body is sent as JSON unless the headers have a Content-Type.
+func (c *openAPIChecker) call(method string, path string, headers map[string]string, body string) map[string]any {
+	req, err := http.NewRequest(method, c.base+path, strings.NewReader(body))
+	if err != nil {
+		c.fail("%s %s: %v", method, path, err)
+		return nil
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.fail("%s %s: %v", method, path, err)
+		return nil
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.fail("%s %s: %v", method, path, err)
+		return nil
+	}
+
+	template, operation := c.operation(method, req.URL.Path)
+	if operation == nil {
+		c.fail("%s %s: no operation of the document matches", method, path)
+		return nil
+	}
+	c.exercised[method+" "+template] = true
+	where := fmt.Sprintf("%s %s (%s %s) %d", method, path, method, template, resp.StatusCode)
+
+	responses, _ := operation["responses"].(map[string]any)
+	response, ok := responses[strconv.Itoa(resp.StatusCode)].(map[string]any)
+	if !ok {
+		c.fail("%s: status not documented, body %s", where, bytes.TrimSpace(raw))
+		return nil
+	}
+	content, ok := response["content"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	media, ok := content[mediaType].(map[string]any)
+	if !ok {
+		c.fail("%s: Content-Type %q not documented", where, resp.Header.Get("Content-Type"))
+		return nil
+	}
+
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		c.fail("%s: %v", where, err)
+		return nil
+	}
+	schema, _ := media["schema"].(map[string]any)
+	if err := c.validate(schema, value, "body"); err != nil {
+		c.fail("%s: %v", where, err)
+	}
+	object, _ := value.(map[string]any)
+	return object
+}
+
+// operation finds the documented operation of the request, the paths with the most literal segments first
+func (c *openAPIChecker) operation(method string, path string) (string, map[string]any) {
+	segments := strings.Split(path, "/")
+	best, bestLiterals := "", -1
+	paths, _ := c.document["paths"].(map[string]any)
+	for template := range paths {
+		templateSegments := strings.Split(template, "/")
+		if len(templateSegments) != len(segments) {
+			continue
+		}
+		literals := 0
+		for i, segment := range templateSegments {
+			if strings.HasPrefix(segment, "{") {
+				if segments[i] == "" {
+					literals = -1
+					break
+				}
+				continue
+			}
+			if segment != segments[i] {
+				literals = -1
+				break
+			}
+			literals++
+		}
+		if literals > bestLiterals {
+			best, bestLiterals = template, literals
+		}
+	}
+	if best == "" {
+		return "", nil
+	}
+	item, _ := paths[best].(map[string]any)
+	operation, _ := item[strings.ToLower(method)].(map[string]any)
+	return best, operation
+}
+
+// validate checks the JSON value against the schema, the properties missing from the schema are errors too
+func (c *openAPIChecker) validate(schema map[string]any, value any, at string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		components, _ := c.document["components"].(map[string]any)
+		schemas, _ := components["schemas"].(map[string]any)
+		resolved, ok := schemas[name].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", at, ref)
+		}
+		return c.validate(resolved, value, at)
+	}
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return fmt.Errorf("%s: null is not allowed", at)
+	}
+	if all, ok := schema["allOf"].([]any); ok {
+		for _, s := range all {
+			sub, _ := s.(map[string]any)
+			if err := c.validate(sub, value, at); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %v is not an object", at, value)
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing required property %s", at, name)
+			}
+		}
+		for name, v := range object {
+			property, ok := properties[name].(map[string]any)
+			if !ok {
+				if properties == nil {
+					continue
+				}
+				return fmt.Errorf("%s: property %s is not documented", at, name)
+			}
+			if err := c.validate(property, v, at+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: %v is not an array", at, value)
+		}
+		items, _ := schema["items"].(map[string]any)
+		for i, v := range array {
+			if err := c.validate(items, v, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: %v is not a string", at, value)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				return fmt.Errorf("%s: %s is not a date-time", at, s)
+			}
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != math.Trunc(n) {
+			return fmt.Errorf("%s: %v is not an integer", at, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: %v is not a boolean", at, value)
+		}
+	}
+	return nil
+}
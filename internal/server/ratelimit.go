@@ -0,0 +1,50 @@
+package server
+
+import (
+	"go-url-short/internal/analytics"
+	"go-url-short/internal/auth"
+	"go-url-short/internal/ratelimit"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// clientKey identifies the client of the request by its API key, or by its address when it is anonymous
+func (s *httpServer) clientKey(r *http.Request) string {
+	if caller, ok := auth.CallerFrom(r.Context()); ok {
+		return "key:" + caller.Key.ID
+	}
+	return "ip:" + analytics.ClientIP(r, s.TrustedProxies)
+}
+
+// seconds rounds up so that a client waiting for the returned seconds gets a token
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// rateLimit limits the requests of each client to the route, each route has its own buckets.
+// The requests are let through when the limiter fails, an unavailable backend must not take the service down.
+func (s *httpServer) rateLimit(route string, limit ratelimit.Limit, next http.HandlerFunc) http.HandlerFunc {
+	if limit.Disabled() {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := s.Limiter.Allow(r.Context(), route+":"+s.clientKey(r), limit)
+		if err != nil {
+			s.Log.Println("Error checking rate limit: ", err)
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Count))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("X-RateLimit-Reset", seconds(res.Reset))
+		if !res.Allowed {
+			w.Header().Set("Retry-After", seconds(res.RetryAfter))
+			writeError(w, r, http.StatusTooManyRequests, CodeRateLimited, "Too many requests, retry in "+seconds(res.RetryAfter)+"s")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,34 @@
+package server
+
+// The requests are read from JSON bodies or from the form params of the same names, see decodeRequest.
+// The fields with omitempty are optional in the OpenAPI document.
+
+type ShortenRequest struct {
+	Url       string `json:"url"`
+	Alias     string `json:"alias,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	Ttl       string `json:"ttl,omitempty"`
+}
+
+type UpdateLinkRequest struct {
+	Url string `json:"url"`
+}
+
+type IssueKeyRequest struct {
+	Owner  string   `json:"owner"`
+	User   string   `json:"user,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+type CreateUserRequest struct {
+	Name string `json:"name"`
+}
+
+type CreateWorkspaceRequest struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+}
+
+type SetMemberRequest struct {
+	Role string `json:"role"`
+}
@@ -1,5 +1,7 @@
 package server
 
+import "time"
+
 type SuccessResponse struct {
 	ShortUrl string `json:"short_url"`
 }
@@ -8,10 +10,113 @@ type HealthResponse struct {
 }
 
 type ShortUrlResponse struct {
-	ShortUrl string `json:"short_url"`
-	Url      string `json:"url"`
+	ShortUrl  string     `json:"short_url"`
+	Url       string     `json:"url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type BatchItemResponse struct {
+	ShortUrl  string     `json:"short_url,omitempty"`
+	Url       string     `json:"url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Status is the status the url would have been answered with by POST /shorten
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Code   string `json:"code,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []BatchItemResponse `json:"results"`
 }
 
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
+
+// ErrorEnvelope is the error of the /v1 API, the other routes answer with ErrorResponse
+type ErrorEnvelope struct {
+	Error APIError `json:"error"`
+}
+
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Url is the rejected url of the invalid_url like codes
+	Url string `json:"url,omitempty"`
+}
+
+type InvalidURLResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+	Url   string `json:"url"`
+}
+
+type LinkResponse struct {
+	Key       string     `json:"key"`
+	ShortUrl  string     `json:"short_url"`
+	Url       string     `json:"url"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Owner     string     `json:"owner,omitempty"`
+}
+
+type ListLinksResponse struct {
+	Links      []LinkResponse `json:"links"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+type DailyCountResponse struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
+
+type StatsResponse struct {
+	Key   string               `json:"key"`
+	Total int64                `json:"total"`
+	Daily []DailyCountResponse `json:"daily"`
+}
+
+type APIKeyResponse struct {
+	ID string `json:"id"`
+	// Key is the secret of the key, only returned when it is issued
+	Key       string     `json:"key,omitempty"`
+	Owner     string     `json:"owner"`
+	User      string     `json:"user,omitempty"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+type ListAPIKeysResponse struct {
+	Keys []APIKeyResponse `json:"keys"`
+}
+
+type UserResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ListUsersResponse struct {
+	Users []UserResponse `json:"users"`
+}
+
+type WorkspaceResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ListWorkspacesResponse struct {
+	Workspaces []WorkspaceResponse `json:"workspaces"`
+}
+
+type MemberResponse struct {
+	Workspace string `json:"workspace"`
+	User      string `json:"user"`
+	Role      string `json:"role"`
+}
+
+type ListMembersResponse struct {
+	Members []MemberResponse `json:"members"`
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"go-url-short/internal/auth"
+	"go-url-short/internal/store"
+	"net/http"
+	"time"
+)
+
+func (s *httpServer) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req CreateUserRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		writeError(w, r, http.StatusBadRequest, CodeMissingParameter, "Missing name params")
+		return
+	}
+
+	id, err := auth.NewID()
+	user := store.User{ID: id, Name: name, CreatedAt: time.Now().Truncate(time.Millisecond)}
+	if err == nil {
+		err = s.Store.CreateUser(r.Context(), user)
+	}
+
+	if err != nil {
+		s.Log.Println("Error creating user: ", err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	s.Log.Printf("Created user(%s)", id)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&UserResponse{ID: user.ID, Name: user.Name, CreatedAt: user.CreatedAt})
+}
+
+func (s *httpServer) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := s.Store.ListUsers(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	response := ListUsersResponse{Users: make([]UserResponse, 0, len(users))}
+	for _, user := range users {
+		response.Users = append(response.Users, UserResponse{ID: user.ID, Name: user.Name, CreatedAt: user.CreatedAt})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&response)
+}
+
+// handleCreateWorkspace creates a workspace with the user of the owner params as its first owner
+func (s *httpServer) handleCreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	var req CreateWorkspaceRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	name, owner := req.Name, req.Owner
+	if name == "" || owner == "" {
+		writeError(w, r, http.StatusBadRequest, CodeMissingParameter, "Missing name or owner params")
+		return
+	}
+
+	id, err := auth.NewID()
+	workspace := store.Workspace{ID: id, Name: name, CreatedAt: time.Now().Truncate(time.Millisecond)}
+	if err == nil {
+		err = s.Store.CreateWorkspace(r.Context(), workspace, store.Member{WorkspaceID: id, UserID: owner, Role: auth.RoleOwner})
+	}
+
+	if err != nil && errors.Is(err, store.ErrUserNotFound) {
+		writeError(w, r, http.StatusBadRequest, CodeUserNotFound, "Not Found user("+owner+")")
+		return
+	}
+
+	if err != nil {
+		s.Log.Println("Error creating workspace: ", err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	s.Log.Printf("Created workspace(%s) owned by user(%s)", id, owner)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&WorkspaceResponse{ID: workspace.ID, Name: workspace.Name, CreatedAt: workspace.CreatedAt})
+}
+
+func (s *httpServer) handleListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	workspaces, err := s.Store.ListWorkspaces(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	response := ListWorkspacesResponse{Workspaces: make([]WorkspaceResponse, 0, len(workspaces))}
+	for _, workspace := range workspaces {
+		response.Workspaces = append(response.Workspaces, WorkspaceResponse{ID: workspace.ID, Name: workspace.Name, CreatedAt: workspace.CreatedAt})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&response)
+}
+
+// handleListMembers lists the members of the workspace to its members and to the admins
+func (s *httpServer) handleListMembers(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	caller, _ := auth.CallerFrom(r.Context())
+	if !caller.CanSee(id) {
+		writeError(w, r, http.StatusForbidden, CodeNotMember, "Not a member of workspace("+id+")")
+		return
+	}
+
+	members, err := s.Store.ListMembers(r.Context(), id)
+	if err != nil && errors.Is(err, store.ErrWorkspaceNotFound) {
+		writeError(w, r, http.StatusNotFound, CodeWorkspaceNotFound, "Not Found workspace("+id+")")
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	response := ListMembersResponse{Members: make([]MemberResponse, 0, len(members))}
+	for _, member := range members {
+		response.Members = append(response.Members, MemberResponse{Workspace: member.WorkspaceID, User: member.UserID, Role: member.Role})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&response)
+}
+
+// handleSetMember adds the user to the workspace or changes its role, only the owners of the workspace and the admins may
+func (s *httpServer) handleSetMember(w http.ResponseWriter, r *http.Request) {
+	id, userID := mux.Vars(r)["id"], mux.Vars(r)["user"]
+	var req SetMemberRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	role := req.Role
+
+	if !auth.ValidRole(role) {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidRole, "role must be "+auth.RoleOwner+", "+auth.RoleEditor+" or "+auth.RoleViewer)
+		return
+	}
+	if !s.checkManages(w, r, id) {
+		return
+	}
+
+	err := s.Store.SetMember(r.Context(), store.Member{WorkspaceID: id, UserID: userID, Role: role})
+	if err != nil && errors.Is(err, store.ErrWorkspaceNotFound) {
+		writeError(w, r, http.StatusNotFound, CodeWorkspaceNotFound, "Not Found workspace("+id+")")
+		return
+	}
+
+	if err != nil && errors.Is(err, store.ErrUserNotFound) {
+		writeError(w, r, http.StatusNotFound, CodeUserNotFound, "Not Found user("+userID+")")
+		return
+	}
+
+	if err != nil && errors.Is(err, store.ErrLastOwner) {
+		writeError(w, r, http.StatusConflict, CodeLastOwner, "user("+userID+") is the last owner of workspace("+id+")")
+		return
+	}
+
+	if err != nil {
+		s.Log.Println("Error setting member: ", err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	s.Log.Printf("Set user(%s) %s of workspace(%s)", userID, role, id)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&MemberResponse{Workspace: id, User: userID, Role: role})
+}
+
+func (s *httpServer) handleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	id, userID := mux.Vars(r)["id"], mux.Vars(r)["user"]
+
+	if !s.checkManages(w, r, id) {
+		return
+	}
+
+	err := s.Store.RemoveMember(r.Context(), id, userID)
+	if err != nil && errors.Is(err, store.ErrMemberNotFound) {
+		writeError(w, r, http.StatusNotFound, CodeMemberNotFound, "Not Found member("+userID+") of workspace("+id+")")
+		return
+	}
+
+	if err != nil && errors.Is(err, store.ErrLastOwner) {
+		writeError(w, r, http.StatusConflict, CodeLastOwner, "user("+userID+") is the last owner of workspace("+id+")")
+		return
+	}
+
+	if err != nil {
+		s.Log.Println("Error removing member: ", err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Unhandled Error")
+		return
+	}
+
+	s.Log.Printf("Removed user(%s) from workspace(%s)", userID, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkManages writes the error response and returns false when the caller may not change the members of the workspace
+func (s *httpServer) checkManages(w http.ResponseWriter, r *http.Request, id string) bool {
+	caller, _ := auth.CallerFrom(r.Context())
+	if caller.Manages(id) {
+		return true
+	}
+	writeError(w, r, http.StatusForbidden, CodeForbidden, "Only the owners of workspace("+id+") may change its members")
+	return false
+}
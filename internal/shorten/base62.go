@@ -20,19 +20,33 @@ const base62keyLength = len(base62charset)
 // ConvertRadix10 converts a integer from an base62 string
 func ConvertRadix10(shortKey string) (int64, error) {
 	var id int64 = 0
-	shortKey = reverse(shortKey)
-	for i, c := range shortKey {
+	for _, c := range shortKey {
 		index := strings.IndexRune(base62charset, c)
 		if index == -1 {
 			return 0, ErrInvalidID{fmt.Errorf("invalid character: %c", c)}
 		}
-		id += int64(index) * int64(math.Pow(float64(base62keyLength), float64(i)))
-
+		if id > (math.MaxInt64-int64(index))/int64(base62keyLength) {
+			return 0, ErrInvalidID{fmt.Errorf("overflows int64: %s", shortKey)}
+		}
+		id = id*int64(base62keyLength) + int64(index)
 	}
 
 	return id, nil
 }
 
+// IsBase62 reports whether s is non-empty and only made of base62 characters
+func IsBase62(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune(base62charset, c) {
+			return false
+		}
+	}
+	return true
+}
+
 // GenerateBase62 converts a string from an integer
 func ConvertRadix62(id int64) string {
 	result := make([]string, 0)
@@ -0,0 +1,75 @@
+package shorten
+
+import "fmt"
+
+// KeyGenerator generates the ids of new short links, the short key of a link is ConvertRadix62(id)
+type KeyGenerator interface {
+	// NextID returns a new positive id for the original URL
+	NextID(originalURL string) (int64, error)
+}
+
+// Seeder is implemented by the generators that must start after the ids already in use
+type Seeder interface {
+	Seed(last int64)
+}
+
+// Retrier is implemented by the generators whose NextID depends on the original URL only,
+// it returns another id for the later attempts of a URL whose id collided
+type Retrier interface {
+	RetryID(originalURL string, attempt int) (int64, error)
+}
+
+// Grower is implemented by the generators whose key length adapts to the number of keys in use
+type Grower interface {
+	Grow(used int)
+}
+
+const (
+	StrategyRandom     = "random"
+	StrategySnowflake  = "snowflake"
+	StrategySequential = "sequential"
+	StrategyHash       = "hash"
+)
+
+type KeyGeneratorConfig struct {
+	Strategy string `default:"" desc:"Key generation strategy (random, snowflake, sequential or hash), empty uses the default of the store"`
+	Length   int    `default:"6" desc:"Key length of the hash strategy and starting key length of the random strategy"`
+}
+
+// NewKeyGenerator creates the generator of the configured strategy, falling back to the given one when none is configured
+func NewKeyGenerator(config *KeyGeneratorConfig, fallback string) (KeyGenerator, error) {
+	strategy, length := fallback, keyLength
+	if config != nil && config.Strategy != "" {
+		strategy = config.Strategy
+	}
+	if config != nil && config.Length > 0 {
+		length = config.Length
+	}
+	if length > maxKeyLength {
+		return nil, fmt.Errorf("key length must be at most %d: %d", maxKeyLength, length)
+	}
+
+	switch strategy {
+	case StrategyRandom:
+		return NewRandomGenerator(length), nil
+	case StrategySnowflake:
+		return NewSnowflakeGenerator()
+	case StrategySequential:
+		return NewSequentialGenerator(0), nil
+	case StrategyHash:
+		return NewHashGenerator(length), nil
+	}
+	return nil, fmt.Errorf("unknown key generation strategy: %s", strategy)
+}
+
+// maxKeyLength is the longest radix62 key whose whole range fits in an int64
+const maxKeyLength = 10
+
+// keyRange returns the ids whose radix62 keys are exactly length characters long
+func keyRange(length int) (min, max int64) {
+	min = 1
+	for i := 1; i < length; i++ {
+		min *= int64(base62keyLength)
+	}
+	return min, min * int64(base62keyLength)
+}
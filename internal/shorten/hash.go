@@ -0,0 +1,38 @@
+package shorten
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// HashGenerator derives the id from the SHA-256 of the original URL,
+// so the same URL first gets the same key of the given length
+type HashGenerator struct {
+	Length int
+}
+
+func NewHashGenerator(length int) *HashGenerator {
+	return &HashGenerator{Length: length}
+}
+
+func (g *HashGenerator) NextID(originalURL string) (int64, error) {
+	return g.id([]byte(originalURL)), nil
+}
+
+// RetryID salts the hash with random bytes, the key of a URL shortened again without
+// being shared or of a real collision being taken for every attempt otherwise
+func (g *HashGenerator) RetryID(originalURL string, attempt int) (int64, error) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return 0, err
+	}
+	return g.id(append([]byte(originalURL+"\x00"), salt...)), nil
+}
+
+func (g *HashGenerator) id(b []byte) int64 {
+	sum := sha256.Sum256(b)
+	min, max := keyRange(g.Length)
+	n := binary.BigEndian.Uint64(sum[:8]) % uint64(max-min)
+	return min + int64(n)
+}
@@ -1,20 +1,54 @@
 package shorten
 
 import (
-	"math/rand"
-	"time"
+	"crypto/rand"
+	"math/big"
+	"sync/atomic"
 )
 
-const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 const keyLength = 6
 
-func GenerateRandomKey() string {
-	// rand.Seed() was deprecated
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	shortKey := make([]byte, keyLength)
-	for i := range shortKey {
-		shortKey[i] = charset[r.Intn(len(charset))]
+// maxKeyspaceUsage is the share of the keyspace that can be in use before random keys grow longer,
+// it bounds the chance of a collision on every attempt
+const maxKeyspaceUsage = 0.01
+
+// RandomGenerator picks a random id among the ones whose key has the current length.
+// The length starts at the given one and grows as the keyspace gets crowded, see Grow.
+type RandomGenerator struct {
+	length atomic.Int64
+}
+
+func NewRandomGenerator(length int) *RandomGenerator {
+	g := &RandomGenerator{}
+	g.length.Store(int64(length))
+	return g
+}
+
+// Length returns the current key length
+func (g *RandomGenerator) Length() int {
+	return int(g.length.Load())
+}
+
+func (g *RandomGenerator) NextID(originalURL string) (int64, error) {
+	min, max := keyRange(g.Length())
+	n, err := rand.Int(rand.Reader, big.NewInt(max-min))
+	if err != nil {
+		return 0, err
 	}
+	return min + n.Int64(), nil
+}
 
-	return string(shortKey)
+// Grow lengthens the keys until the used keys fill at most maxKeyspaceUsage of the keyspace
+func (g *RandomGenerator) Grow(used int) {
+	for {
+		length := g.length.Load()
+		if length >= maxKeyLength {
+			return
+		}
+		min, max := keyRange(int(length))
+		if float64(used) <= float64(max-min)*maxKeyspaceUsage {
+			return
+		}
+		g.length.CompareAndSwap(length, length+1)
+	}
 }
@@ -0,0 +1,27 @@
+package shorten
+
+import "sync/atomic"
+
+// SequentialGenerator hands out increasing ids from an in-process counter.
+// It only suits single node deployments as every process counts on its own.
+type SequentialGenerator struct {
+	last int64
+}
+
+func NewSequentialGenerator(last int64) *SequentialGenerator {
+	return &SequentialGenerator{last: last}
+}
+
+func (g *SequentialGenerator) NextID(originalURL string) (int64, error) {
+	return atomic.AddInt64(&g.last, 1), nil
+}
+
+// Seed moves the counter past the last id already in use
+func (g *SequentialGenerator) Seed(last int64) {
+	for {
+		current := atomic.LoadInt64(&g.last)
+		if last <= current || atomic.CompareAndSwapInt64(&g.last, current, last) {
+			return
+		}
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"github.com/bwmarrin/snowflake"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 )
 
+var epoch = time.Date(2023, 10, 29, 0, 0, 0, 0, time.UTC).UnixMilli()
+
 func getNewNode() (*snowflake.Node, error) {
 	nodeId, found := os.LookupEnv("NODE_ID")
 	if !found {
@@ -21,17 +24,41 @@ func getNewNode() (*snowflake.Node, error) {
 	return snowflake.NewNode(id)
 }
 
+var (
+	processNode     *snowflake.Node
+	processNodeErr  error
+	processNodeOnce sync.Once
+)
+
+// SnowflakeGenerator generates time ordered ids unique across the nodes of a deployment
+//
 // +--------------------------------------------------------------------------+
 // | 1 Bit Unused | 41 Bit Timestamp |  10 Bit NodeID  |   12 Bit Sequence ID |
 // +--------------------------------------------------------------------------+
 // | 0           | 0 ... 10011010100111010101011000 | 00000001 | 000000000000 |
 // +--------------------------------------------------------------------------+
-func GenerateSnowFlakeKey() (int64, error) {
-	snowflake.Epoch = time.Date(2023, 10, 29, 0, 0, 0, 0, time.UTC).UnixMilli()
-	node, err := getNewNode()
-	if err != nil {
-		return 0, err
+type SnowflakeGenerator struct {
+	node *snowflake.Node
+}
+
+// NewSnowflakeGenerator returns a generator sharing the node of the process,
+// two nodes with the same NODE_ID would hand out the same ids within a millisecond
+func NewSnowflakeGenerator() (*SnowflakeGenerator, error) {
+	processNodeOnce.Do(func() {
+		snowflake.Epoch = epoch
+		processNode, processNodeErr = getNewNode()
+	})
+	if processNodeErr != nil {
+		return nil, processNodeErr
 	}
-	id := node.Generate()
-	return int64(id), nil
+	return &SnowflakeGenerator{node: processNode}, nil
+}
+
+func (g *SnowflakeGenerator) NextID(originalURL string) (int64, error) {
+	return int64(g.node.Generate()), nil
+}
+
+// SnowflakeTime returns when the snowflake id was generated, the times of the ids of the other strategies are meaningless
+func SnowflakeTime(id int64) time.Time {
+	return time.UnixMilli(id>>(snowflake.NodeBits+snowflake.StepBits) + epoch)
 }
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// APIKey is an issued API key, only the SHA-256 hash of its secret is stored
+type APIKey struct {
+	ID   string
+	Hash []byte
+	// Owner is the workspace the key acts for, it is recorded on the links created with the key
+	Owner string
+	// UserID is the user the key acts as, empty for the keys issued before users existed
+	UserID    string
+	Scopes    []string
+	CreatedAt time.Time
+	// RevokedAt is zero for the keys still in use
+	RevokedAt time.Time
+}
+
+func (k APIKey) Revoked() bool {
+	return !k.RevokedAt.IsZero()
+}
+
+// joinScopes and splitScopes store the scopes as a single comma separated column
+func joinScopes(scopes []string) string {
+	return strings.Join(scopes, ",")
+}
+
+func splitScopes(scopes string) []string {
+	if scopes == "" {
+		return nil
+	}
+	return strings.Split(scopes, ",")
+}
+
+func sortAPIKeys(keys []APIKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		return createdBefore(keys[i].CreatedAt, keys[i].ID, keys[j].CreatedAt, keys[j].ID)
+	})
+}
@@ -0,0 +1,257 @@
+package store
+
+import (
+	"context"
+	generator "go-url-short/internal/shorten"
+	"hash/fnv"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type BloomConfig struct {
+	Capacity          int           `default:"0" desc:"Number of keys the bloom filter is sized for, 0 disables it"`
+	FalsePositiveRate float64       `default:"0.01" desc:"Target false positive rate of the bloom filter"`
+	Interval          time.Duration `default:"10m" desc:"How often the bloom filter is rebuilt from the store"`
+}
+
+// bloomRebuildPage is the number of links listed at once while rebuilding the filter
+const bloomRebuildPage = 1000
+
+// bloomClockSkew is how far apart the clocks of the instances sharing a store may be
+const bloomClockSkew = time.Minute
+
+// bloomFilter is safe for concurrent use, bits are only ever set
+type bloomFilter struct {
+	bits   []uint64
+	hashes int
+	// started is when the rebuild listing the keys of the store began
+	started time.Time
+}
+
+func newBloomFilter(capacity int, rate float64) *bloomFilter {
+	if capacity < 1 {
+		capacity = 1
+	}
+	m := math.Ceil(-float64(capacity) * math.Log(rate) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(m / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{
+		bits:    make([]uint64, (int(m)+63)/64),
+		hashes:  k,
+		started: time.Now(),
+	}
+}
+
+// positions derives the bit positions of the key by double hashing the two halves of its FNV-1a hash
+func (f *bloomFilter) positions(key string, fn func(word int, mask uint64) bool) bool {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+
+	m := uint64(len(f.bits) * 64)
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		if !fn(int(bit/64), 1<<(bit%64)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) add(key string) {
+	f.positions(key, func(word int, mask uint64) bool {
+		for {
+			old := atomic.LoadUint64(&f.bits[word])
+			if old&mask != 0 || atomic.CompareAndSwapUint64(&f.bits[word], old, old|mask) {
+				return true
+			}
+		}
+	})
+}
+
+// mayContain is false only for keys that were never added
+func (f *bloomFilter) mayContain(key string) bool {
+	return f.positions(key, func(word int, mask uint64) bool {
+		return atomic.LoadUint64(&f.bits[word])&mask != 0
+	})
+}
+
+// BloomFilter rejects the unknown short keys without querying the store.
+// The filter is rebuilt from the whole store periodically, which drops the deleted keys,
+// and the keys created through this instance are added as they are created.
+// In front of a store shared with other instances, the snowflake keys generated after the last rebuild began
+// are still looked up in the store, as they may have been created through another instance. The other keys
+// created through another instance, such as aliases and random keys, are rejected until the next rebuild.
+// The first build runs in the background and every key is looked up in the store until it completes.
+// On Lambda the build only progresses while an invocation runs, as the execution environment is frozen in between.
+type BloomFilter struct {
+	Store
+	capacity int
+	rate     float64
+	// filter is nil until the first build, every key is looked up in the store meanwhile
+	filter atomic.Pointer[bloomFilter]
+	// mu guards building, the filter being rebuilt which must receive the new keys too
+	mu       sync.Mutex
+	building *bloomFilter
+	// shared is set when other instances create keys in the store too
+	shared bool
+	stop   chan struct{}
+	Log    *log.Logger
+}
+
+func NewBloomFilter(st Store, config *BloomConfig, shared bool) *BloomFilter {
+	b := &BloomFilter{
+		Store:    st,
+		capacity: config.Capacity,
+		rate:     config.FalsePositiveRate,
+		shared:   shared,
+		stop:     make(chan struct{}),
+		Log:      log.New(log.Writer(), "BLOOMFILTER:", log.LstdFlags),
+	}
+	go b.rebuildLoop(config.Interval)
+	return b
+}
+
+func (b *BloomFilter) rebuildLoop(interval time.Duration) {
+	count := 0
+	for {
+		n, err := b.rebuild(count)
+		if err != nil {
+			b.Log.Println("Error rebuilding bloom filter: ", err)
+		} else {
+			b.Log.Printf("Rebuilt bloom filter with %d keys", n)
+			// An overfilled filter lets too many unknown keys through, it is rebuilt right away for the actual count
+			overfilled := n > b.capacityFor(count)
+			count = n
+			if overfilled {
+				continue
+			}
+		}
+
+		if interval <= 0 {
+			return
+		}
+		select {
+		case <-b.stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// capacityFor sizes the filter for twice the number of keys once they outgrow the configured capacity
+func (b *BloomFilter) capacityFor(count int) int {
+	if 2*count > b.capacity {
+		return 2 * count
+	}
+	return b.capacity
+}
+
+// rebuild lists every key of the store into a new filter sized for the previous count of keys
+func (b *BloomFilter) rebuild(count int) (int, error) {
+	f := newBloomFilter(b.capacityFor(count), b.rate)
+
+	b.mu.Lock()
+	b.building = f
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.building = nil
+		b.mu.Unlock()
+	}()
+
+	n := 0
+	cursor := ""
+	for {
+		links, next, err := b.Store.List(context.Background(), cursor, bloomRebuildPage)
+		if err != nil {
+			return n, err
+		}
+		for _, link := range links {
+			f.add(link.Key)
+		}
+		n += len(links)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	b.filter.Store(f)
+	return n, nil
+}
+
+func (b *BloomFilter) add(shortKey string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if f := b.filter.Load(); f != nil {
+		f.add(shortKey)
+	}
+	if b.building != nil {
+		b.building.add(shortKey)
+	}
+}
+
+func (b *BloomFilter) mayContain(shortKey string) bool {
+	f := b.filter.Load()
+	if f == nil || f.mayContain(shortKey) {
+		return true
+	}
+	return b.shared && generatedSince(shortKey, f.started)
+}
+
+// generatedSince reports whether the key is a snowflake key generated after the given time, by any instance.
+// The keys claiming to be generated in the future are not, they are the keys of other strategies or guesses.
+func generatedSince(shortKey string, since time.Time) bool {
+	id, err := generator.ConvertRadix10(shortKey)
+	if err != nil {
+		return false
+	}
+	generated := generator.SnowflakeTime(id)
+	return generated.After(since.Add(-bloomClockSkew)) && generated.Before(time.Now().Add(bloomClockSkew))
+}
+
+func (b *BloomFilter) Get(ctx context.Context, shortKey string) (string, error) {
+	if !b.mayContain(shortKey) {
+		return "", ErrKeyNotFound
+	}
+	return b.Store.Get(ctx, shortKey)
+}
+
+func (b *BloomFilter) GetLink(ctx context.Context, shortKey string) (Link, error) {
+	if !b.mayContain(shortKey) {
+		return Link{}, ErrKeyNotFound
+	}
+	return b.Store.GetLink(ctx, shortKey)
+}
+
+func (b *BloomFilter) Set(ctx context.Context, originalURL string, opts SetOptions) (string, error) {
+	shortKey, err := b.Store.Set(ctx, originalURL, opts)
+	if err != nil {
+		return "", err
+	}
+	b.add(shortKey)
+	return shortKey, nil
+}
+
+func (b *BloomFilter) SetBatch(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	// The links saved before an error are added too, they exist all the same
+	results, err := b.Store.SetBatch(ctx, items)
+	for _, res := range results {
+		if res.Err == nil {
+			b.add(res.Key)
+		}
+	}
+	return results, err
+}
+
+func (b *BloomFilter) DbClose(ctx context.Context) {
+	close(b.stop)
+	b.Store.DbClose(ctx)
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"errors"
+	generator "go-url-short/internal/shorten"
+	"testing"
+	"time"
+)
+
+// newBuiltBloomFilter returns a filter in front of the store once its first build completed
+func newBuiltBloomFilter(t *testing.T, st Store, shared bool) *BloomFilter {
+	b := NewBloomFilter(st, &BloomConfig{Capacity: 1000, FalsePositiveRate: 0.01}, shared)
+	t.Cleanup(func() { close(b.stop) })
+	for deadline := time.Now().Add(5 * time.Second); b.filter.Load() == nil; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the bloom filter was not built")
+		}
+	}
+	return b
+}
+
+func TestBloomFilterSharedStore(t *testing.T) {
+	ctx := context.Background()
+	// st stands for the store shared with another instance, the links set on it directly are created through that instance
+	st := NewInMemStore(nil, newTestKeyGenerator(t, generator.StrategySnowflake))
+	defer st.DbClose(ctx)
+	shared, local := newBuiltBloomFilter(t, st, true), newBuiltBloomFilter(t, st, false)
+
+	key, err := st.Set(ctx, "https://example.com/elsewhere", SetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shared.Get(ctx, key); err != nil {
+		t.Errorf("Get(%s) of a key created through another instance since the build: %v", key, err)
+	}
+	if _, err := local.Get(ctx, key); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get(%s) in front of a store of its own got %v, want ErrKeyNotFound", key, err)
+	}
+
+	// Unknown keys and the keys generated before the build are still rejected without the store
+	old := generator.ConvertRadix62(1 << 22)
+	if _, err := st.Set(ctx, "https://example.com/old", SetOptions{Alias: old}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{old, "zzzzzzzzzzz", "not-a-key"} {
+		if shared.mayContain(key) {
+			t.Errorf("mayContain(%s) = true, want the filter to reject it", key)
+		}
+	}
+}
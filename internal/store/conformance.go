@@ -0,0 +1,807 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	generator "go-url-short/internal/shorten"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// conformanceWorkers is the number of goroutines of the concurrency cases
+const conformanceWorkers = 32
+
+type conformanceCase struct {
+	name string
+	run  func(ctx context.Context, st Store) error
+}
+
+var conformanceCases = []conformanceCase{
+	{"round trip", conformRoundTrip},
+	{"not found", conformNotFound},
+	{"alias", conformAlias},
+	{"duplicate url", conformDuplicateURL},
+	{"update", conformUpdate},
+	{"delete", conformDelete},
+	{"expiry", conformExpiry},
+	{"list", conformList},
+	{"owner", conformOwner},
+	{"api keys", conformAPIKeys},
+	{"workspaces", conformWorkspaces},
+	{"batch", conformBatch},
+	{"concurrent set", conformConcurrentSet},
+	{"concurrent alias", conformConcurrentAlias},
+	{"concurrent duplicate url", conformConcurrentDedup},
+	{"canceled context", conformCanceledContext},
+}
+
+// CheckConformance runs the behavior every Store implementation must share against the store
+// and returns the failures of all the cases joined, or nil when the store conforms.
+// The cases only look at the links they create, so the store may already hold other links,
+// but PurgeExpired removes every expired link: run it against a dedicated database.
+func CheckConformance(ctx context.Context, st Store) error {
+	var errs []error
+	for _, c := range conformanceCases {
+		if err := c.run(ctx, st); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var conformanceSeq atomic.Int64
+
+// unique returns a base62 suffix that no other case nor previous run has used
+func unique() string {
+	return generator.ConvertRadix62(time.Now().UnixNano()) + generator.ConvertRadix62(conformanceSeq.Add(1))
+}
+
+func uniqueURL() string {
+	return "https://example.com/conformance/" + unique()
+}
+
+func uniqueAlias() string {
+	return "conformance" + unique()
+}
+
+// expectURL checks that the short key resolves to the url
+func expectURL(ctx context.Context, st Store, shortKey string, url string) error {
+	got, err := st.Get(ctx, shortKey)
+	if err != nil {
+		return fmt.Errorf("Get(%s): %w", shortKey, err)
+	}
+	if got != url {
+		return fmt.Errorf("Get(%s) = %s, want %s", shortKey, got, url)
+	}
+	return nil
+}
+
+func expectErr(op string, err error, want error) error {
+	if !errors.Is(err, want) {
+		return fmt.Errorf("%s returned %v, want %v", op, err, want)
+	}
+	return nil
+}
+
+func conformRoundTrip(ctx context.Context, st Store) error {
+	url := uniqueURL()
+	before := time.Now().Add(-time.Second)
+	key, err := st.Set(ctx, url, SetOptions{})
+	if err != nil {
+		return fmt.Errorf("Set: %w", err)
+	}
+	if key == "" || !generator.IsBase62(key) {
+		return fmt.Errorf("Set returned the key %q, want a base62 key", key)
+	}
+	if err := expectURL(ctx, st, key, url); err != nil {
+		return err
+	}
+
+	link, err := st.GetLink(ctx, key)
+	if err != nil {
+		return fmt.Errorf("GetLink(%s): %w", key, err)
+	}
+	if link.Key != key || link.URL != url {
+		return fmt.Errorf("GetLink(%s) = %s -> %s, want %s -> %s", key, link.Key, link.URL, key, url)
+	}
+	if link.CreatedAt.Before(before) || !link.ExpiresAt.IsZero() {
+		return fmt.Errorf("GetLink(%s) created at %s expiring at %s, want created now and never expiring", key, link.CreatedAt, link.ExpiresAt)
+	}
+	return nil
+}
+
+func conformNotFound(ctx context.Context, st Store) error {
+	key := uniqueAlias()
+	_, err := st.Get(ctx, key)
+	if err := expectErr("Get", err, ErrKeyNotFound); err != nil {
+		return err
+	}
+	_, err = st.GetLink(ctx, key)
+	if err := expectErr("GetLink", err, ErrKeyNotFound); err != nil {
+		return err
+	}
+	if err := expectErr("Update", st.Update(ctx, key, uniqueURL()), ErrKeyNotFound); err != nil {
+		return err
+	}
+	return expectErr("Delete", st.Delete(ctx, key), ErrKeyNotFound)
+}
+
+func conformAlias(ctx context.Context, st Store) error {
+	alias, url := uniqueAlias(), uniqueURL()
+	key, err := st.Set(ctx, url, SetOptions{Alias: alias})
+	if err != nil {
+		return fmt.Errorf("Set(alias=%s): %w", alias, err)
+	}
+	if key != alias {
+		return fmt.Errorf("Set(alias=%s) returned the key %s", alias, key)
+	}
+	if err := expectURL(ctx, st, alias, url); err != nil {
+		return err
+	}
+
+	_, err = st.Set(ctx, uniqueURL(), SetOptions{Alias: alias})
+	if err := expectErr("Set with a taken alias", err, ErrKeyAlreadyExists); err != nil {
+		return err
+	}
+	// The taken alias keeps its link
+	return expectURL(ctx, st, alias, url)
+}
+
+// conformDuplicateURL shares the key of a url shortened twice in the same dedup scope by the same owner only
+func conformDuplicateURL(ctx context.Context, st Store) error {
+	url, scope := uniqueURL(), "conformance"+unique()
+	first, err := st.Set(ctx, url, SetOptions{DedupScope: scope})
+	if err != nil {
+		return fmt.Errorf("Set: %w", err)
+	}
+	second, err := st.Set(ctx, url, SetOptions{DedupScope: scope})
+	if err != nil {
+		return fmt.Errorf("Set of the same url: %w", err)
+	}
+	if second != first {
+		return fmt.Errorf("Set of the same url in the same scope returned %s, want %s", second, first)
+	}
+	if err := expectURL(ctx, st, first, url); err != nil {
+		return err
+	}
+
+	// The same url shortened by two owners gets a key for each, shared by the later Set of its owner only
+	owners := []string{"owner" + unique(), "owner" + unique()}
+	owned := make([]string, len(owners))
+	for i, owner := range owners {
+		if owned[i], err = st.Set(ctx, url, SetOptions{DedupScope: scope, Owner: owner}); err != nil {
+			return fmt.Errorf("Set of the same url by %s: %w", owner, err)
+		}
+		again, err := st.Set(ctx, url, SetOptions{DedupScope: scope, Owner: owner})
+		if err != nil {
+			return fmt.Errorf("Set of the same url by %s again: %w", owner, err)
+		}
+		if owned[i] == first || again != owned[i] {
+			return fmt.Errorf("Set of the same url by %s returned %s then %s, want a key of its own shared with itself", owner, owned[i], again)
+		}
+	}
+	if owned[0] == owned[1] {
+		return fmt.Errorf("Set of the same url by two owners returned the same key %s", owned[0])
+	}
+
+	// Neither another scope, no scope, an expiry nor an alias share the link
+	for _, opts := range []SetOptions{
+		{DedupScope: scope + "other"},
+		{},
+		{DedupScope: scope, ExpiresAt: time.Now().Add(time.Hour)},
+		{DedupScope: scope, Alias: uniqueAlias()},
+	} {
+		key, err := st.Set(ctx, url, opts)
+		if err != nil {
+			return fmt.Errorf("Set of the same url with %+v: %w", opts, err)
+		}
+		if key == first {
+			return fmt.Errorf("Set of the same url with %+v returned the shared key %s", opts, key)
+		}
+		if err := expectURL(ctx, st, key, url); err != nil {
+			return err
+		}
+	}
+
+	// A link pointed elsewhere or deleted is no longer shared
+	if err := st.Update(ctx, first, uniqueURL()); err != nil {
+		return fmt.Errorf("Update(%s): %w", first, err)
+	}
+	third, err := st.Set(ctx, url, SetOptions{DedupScope: scope})
+	if err != nil {
+		return fmt.Errorf("Set of the same url after an update: %w", err)
+	}
+	if third == first {
+		return fmt.Errorf("Set of the same url after an update returned the updated key %s", first)
+	}
+	if err := st.Delete(ctx, third); err != nil {
+		return fmt.Errorf("Delete(%s): %w", third, err)
+	}
+	fourth, err := st.Set(ctx, url, SetOptions{DedupScope: scope})
+	if err != nil {
+		return fmt.Errorf("Set of the same url after a delete: %w", err)
+	}
+	if fourth == third {
+		return fmt.Errorf("Set of the same url after a delete returned the deleted key %s", third)
+	}
+	return expectURL(ctx, st, fourth, url)
+}
+
+// conformConcurrentDedup races to shorten the same url in the same scope, every Set must return the same key
+func conformConcurrentDedup(ctx context.Context, st Store) error {
+	url, scope := uniqueURL(), "conformance"+unique()
+	keys := make([]string, conformanceWorkers)
+	errs := make([]error, conformanceWorkers)
+	var wg sync.WaitGroup
+	for i := range keys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			keys[i], errs[i] = st.Set(ctx, url, SetOptions{DedupScope: scope})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, key := range keys {
+		if errs[i] != nil {
+			return fmt.Errorf("Set: %w", errs[i])
+		}
+		if key != keys[0] {
+			return fmt.Errorf("Set of the same url returned both %s and %s", keys[0], key)
+		}
+	}
+	return expectURL(ctx, st, keys[0], url)
+}
+
+func conformUpdate(ctx context.Context, st Store) error {
+	key, err := st.Set(ctx, uniqueURL(), SetOptions{})
+	if err != nil {
+		return fmt.Errorf("Set: %w", err)
+	}
+	url := uniqueURL()
+	if err := st.Update(ctx, key, url); err != nil {
+		return fmt.Errorf("Update(%s): %w", key, err)
+	}
+	if err := expectURL(ctx, st, key, url); err != nil {
+		return err
+	}
+
+	alias := uniqueAlias()
+	if _, err := st.Set(ctx, uniqueURL(), SetOptions{Alias: alias}); err != nil {
+		return fmt.Errorf("Set(alias=%s): %w", alias, err)
+	}
+	if err := st.Update(ctx, alias, url); err != nil {
+		return fmt.Errorf("Update(%s): %w", alias, err)
+	}
+	return expectURL(ctx, st, alias, url)
+}
+
+func conformDelete(ctx context.Context, st Store) error {
+	key, err := st.Set(ctx, uniqueURL(), SetOptions{})
+	if err != nil {
+		return fmt.Errorf("Set: %w", err)
+	}
+	if err := st.Delete(ctx, key); err != nil {
+		return fmt.Errorf("Delete(%s): %w", key, err)
+	}
+	_, err = st.Get(ctx, key)
+	if err := expectErr("Get of a deleted key", err, ErrKeyNotFound); err != nil {
+		return err
+	}
+	return expectErr("Delete of a deleted key", st.Delete(ctx, key), ErrKeyNotFound)
+}
+
+func conformExpiry(ctx context.Context, st Store) error {
+	url := uniqueURL()
+	expiresAt := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	key, err := st.Set(ctx, url, SetOptions{ExpiresAt: expiresAt})
+	if err != nil {
+		return fmt.Errorf("Set: %w", err)
+	}
+	_, err = st.Get(ctx, key)
+	if err := expectErr("Get of an expired key", err, ErrKeyExpired); err != nil {
+		return err
+	}
+
+	link, err := st.GetLink(ctx, key)
+	if err != nil {
+		return fmt.Errorf("GetLink of an expired key: %w", err)
+	}
+	if link.URL != url || !link.ExpiresAt.Equal(expiresAt) {
+		return fmt.Errorf("GetLink(%s) = %s expiring at %s, want %s expiring at %s", key, link.URL, link.ExpiresAt, url, expiresAt)
+	}
+
+	alive, err := st.Set(ctx, uniqueURL(), SetOptions{ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		return fmt.Errorf("Set: %w", err)
+	}
+	purged, err := st.PurgeExpired(ctx, time.Now())
+	if err != nil {
+		return fmt.Errorf("PurgeExpired: %w", err)
+	}
+	if purged < 1 {
+		return fmt.Errorf("PurgeExpired deleted %d links, want at least 1", purged)
+	}
+	_, err = st.GetLink(ctx, key)
+	if err := expectErr("GetLink of a purged key", err, ErrKeyNotFound); err != nil {
+		return err
+	}
+	if _, err := st.Get(ctx, alive); err != nil {
+		return fmt.Errorf("Get of a link expiring later after PurgeExpired: %w", err)
+	}
+	return nil
+}
+
+func conformList(ctx context.Context, st Store) error {
+	created := make(map[string]string)
+	for i := 0; i < 5; i++ {
+		url := uniqueURL()
+		key, err := st.Set(ctx, url, SetOptions{})
+		if err != nil {
+			return fmt.Errorf("Set: %w", err)
+		}
+		created[key] = url
+	}
+	alias, url := uniqueAlias(), uniqueURL()
+	if _, err := st.Set(ctx, url, SetOptions{Alias: alias}); err != nil {
+		return fmt.Errorf("Set(alias=%s): %w", alias, err)
+	}
+	created[alias] = url
+
+	seen := make(map[string]bool)
+	cursor := ""
+	for {
+		links, next, err := st.List(ctx, cursor, 2)
+		if err != nil {
+			return fmt.Errorf("List(%q): %w", cursor, err)
+		}
+		if len(links) > 2 {
+			return fmt.Errorf("List(%q) returned %d links, want at most 2", cursor, len(links))
+		}
+		for _, link := range links {
+			if seen[link.Key] {
+				return fmt.Errorf("List returned the key %s twice", link.Key)
+			}
+			seen[link.Key] = true
+			if url, found := created[link.Key]; found && link.URL != url {
+				return fmt.Errorf("List returned %s -> %s, want %s", link.Key, link.URL, url)
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	for key := range created {
+		if !seen[key] {
+			return fmt.Errorf("List did not return the key %s", key)
+		}
+	}
+	return nil
+}
+
+func conformOwner(ctx context.Context, st Store) error {
+	owner := "owner" + unique()
+	key, err := st.Set(ctx, uniqueURL(), SetOptions{Owner: owner})
+	if err != nil {
+		return fmt.Errorf("Set: %w", err)
+	}
+	alias, err := st.Set(ctx, uniqueURL(), SetOptions{Alias: uniqueAlias(), Owner: owner})
+	if err != nil {
+		return fmt.Errorf("Set alias: %w", err)
+	}
+	anonymous, err := st.Set(ctx, uniqueURL(), SetOptions{})
+	if err != nil {
+		return fmt.Errorf("Set: %w", err)
+	}
+
+	for k, want := range map[string]string{key: owner, alias: owner, anonymous: ""} {
+		link, err := st.GetLink(ctx, k)
+		if err != nil {
+			return fmt.Errorf("GetLink(%s): %w", k, err)
+		}
+		if link.Owner != want {
+			return fmt.Errorf("GetLink(%s) owned by %q, want %q", k, link.Owner, want)
+		}
+	}
+
+	links, next, err := st.ListByOwner(ctx, owner, "", 1)
+	if err != nil {
+		return fmt.Errorf("ListByOwner: %w", err)
+	}
+	if len(links) != 1 || next == "" {
+		return fmt.Errorf("ListByOwner returned %d links and cursor %q, want 1 link and a cursor", len(links), next)
+	}
+	rest, next, err := st.ListByOwner(ctx, owner, next, 10)
+	if err != nil {
+		return fmt.Errorf("ListByOwner: %w", err)
+	}
+	if len(rest) != 1 || next != "" {
+		return fmt.Errorf("ListByOwner returned %d links and cursor %q, want the last link", len(rest), next)
+	}
+	got := map[string]bool{links[0].Key: true, rest[0].Key: true}
+	if !got[key] || !got[alias] {
+		return fmt.Errorf("ListByOwner returned %v, want %s and %s", got, key, alias)
+	}
+
+	if err := st.Delete(ctx, key); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	links, _, err = st.ListByOwner(ctx, owner, "", 10)
+	if err != nil {
+		return fmt.Errorf("ListByOwner: %w", err)
+	}
+	if len(links) != 1 || links[0].Key != alias {
+		return fmt.Errorf("ListByOwner after Delete returned %d links, want only %s", len(links), alias)
+	}
+	return nil
+}
+
+func conformWorkspaces(ctx context.Context, st Store) error {
+	now := time.Now().Truncate(time.Millisecond)
+	owner, editor := User{ID: unique(), Name: "owner", CreatedAt: now}, User{ID: unique(), Name: "editor", CreatedAt: now}
+	for _, user := range []User{owner, editor} {
+		if err := st.CreateUser(ctx, user); err != nil {
+			return fmt.Errorf("CreateUser: %w", err)
+		}
+	}
+	err := st.CreateUser(ctx, owner)
+	if err := expectErr("CreateUser with a taken id", err, ErrAlreadyExists); err != nil {
+		return err
+	}
+
+	workspace := Workspace{ID: unique(), Name: "team", CreatedAt: now}
+	err = st.CreateWorkspace(ctx, workspace, Member{WorkspaceID: workspace.ID, UserID: unique(), Role: "owner"})
+	if err := expectErr("CreateWorkspace with an unknown user", err, ErrUserNotFound); err != nil {
+		return err
+	}
+	if err := st.CreateWorkspace(ctx, workspace, Member{WorkspaceID: workspace.ID, UserID: owner.ID, Role: "owner"}); err != nil {
+		return fmt.Errorf("CreateWorkspace: %w", err)
+	}
+	err = st.CreateWorkspace(ctx, workspace, Member{WorkspaceID: workspace.ID, UserID: owner.ID, Role: "owner"})
+	if err := expectErr("CreateWorkspace with a taken id", err, ErrAlreadyExists); err != nil {
+		return err
+	}
+
+	err = st.SetMember(ctx, Member{WorkspaceID: unique(), UserID: editor.ID, Role: "editor"})
+	if err := expectErr("SetMember of an unknown workspace", err, ErrWorkspaceNotFound); err != nil {
+		return err
+	}
+	err = st.SetMember(ctx, Member{WorkspaceID: workspace.ID, UserID: unique(), Role: "editor"})
+	if err := expectErr("SetMember of an unknown user", err, ErrUserNotFound); err != nil {
+		return err
+	}
+	if err := st.SetMember(ctx, Member{WorkspaceID: workspace.ID, UserID: editor.ID, Role: "viewer"}); err != nil {
+		return fmt.Errorf("SetMember: %w", err)
+	}
+	if err := st.SetMember(ctx, Member{WorkspaceID: workspace.ID, UserID: editor.ID, Role: "editor"}); err != nil {
+		return fmt.Errorf("SetMember again: %w", err)
+	}
+
+	member, err := st.GetMember(ctx, workspace.ID, editor.ID)
+	if err != nil {
+		return fmt.Errorf("GetMember: %w", err)
+	}
+	if member.Role != "editor" {
+		return fmt.Errorf("GetMember role %q, want the last role set", member.Role)
+	}
+	members, err := st.ListMembers(ctx, workspace.ID)
+	if err != nil {
+		return fmt.Errorf("ListMembers: %w", err)
+	}
+	want := []Member{{workspace.ID, owner.ID, "owner"}, {workspace.ID, editor.ID, "editor"}}
+	sortMembers(want)
+	if fmt.Sprint(members) != fmt.Sprint(want) {
+		return fmt.Errorf("ListMembers = %v, want %v", members, want)
+	}
+	_, err = st.ListMembers(ctx, unique())
+	if err := expectErr("ListMembers of an unknown workspace", err, ErrWorkspaceNotFound); err != nil {
+		return err
+	}
+
+	if err := st.RemoveMember(ctx, workspace.ID, editor.ID); err != nil {
+		return fmt.Errorf("RemoveMember: %w", err)
+	}
+	err = st.RemoveMember(ctx, workspace.ID, editor.ID)
+	if err := expectErr("RemoveMember twice", err, ErrMemberNotFound); err != nil {
+		return err
+	}
+	_, err = st.GetMember(ctx, workspace.ID, editor.ID)
+	if err := expectErr("GetMember of a removed member", err, ErrMemberNotFound); err != nil {
+		return err
+	}
+
+	err = st.SetMember(ctx, Member{WorkspaceID: workspace.ID, UserID: owner.ID, Role: "editor"})
+	if err := expectErr("SetMember demoting the last owner", err, ErrLastOwner); err != nil {
+		return err
+	}
+	err = st.RemoveMember(ctx, workspace.ID, owner.ID)
+	if err := expectErr("RemoveMember of the last owner", err, ErrLastOwner); err != nil {
+		return err
+	}
+	if err := st.SetMember(ctx, Member{WorkspaceID: workspace.ID, UserID: owner.ID, Role: "owner"}); err != nil {
+		return fmt.Errorf("SetMember keeping the last owner: %w", err)
+	}
+	if err := st.SetMember(ctx, Member{WorkspaceID: workspace.ID, UserID: editor.ID, Role: "owner"}); err != nil {
+		return fmt.Errorf("SetMember of a second owner: %w", err)
+	}
+	if err := st.SetMember(ctx, Member{WorkspaceID: workspace.ID, UserID: owner.ID, Role: "viewer"}); err != nil {
+		return fmt.Errorf("SetMember demoting an owner: %w", err)
+	}
+	if err := st.RemoveMember(ctx, workspace.ID, owner.ID); err != nil {
+		return fmt.Errorf("RemoveMember of a former owner: %w", err)
+	}
+	err = st.RemoveMember(ctx, workspace.ID, editor.ID)
+	if err := expectErr("RemoveMember of the new last owner", err, ErrLastOwner); err != nil {
+		return err
+	}
+
+	users, err := st.ListUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("ListUsers: %w", err)
+	}
+	found := 0
+	for _, user := range users {
+		if user.ID == owner.ID || user.ID == editor.ID {
+			if !user.CreatedAt.Equal(now) {
+				return fmt.Errorf("ListUsers created at %s, want %s", user.CreatedAt, now)
+			}
+			found++
+		}
+	}
+	if found != 2 {
+		return fmt.Errorf("ListUsers is missing the users")
+	}
+
+	workspaces, err := st.ListWorkspaces(ctx)
+	if err != nil {
+		return fmt.Errorf("ListWorkspaces: %w", err)
+	}
+	for _, w := range workspaces {
+		if w.ID == workspace.ID {
+			if w.Name != workspace.Name || !w.CreatedAt.Equal(now) {
+				return fmt.Errorf("ListWorkspaces returned %+v, want %+v", w, workspace)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("ListWorkspaces is missing the workspace %s", workspace.ID)
+}
+
+func conformAPIKeys(ctx context.Context, st Store) error {
+	id := unique()
+	hash := []byte("conformance" + id)
+	key := APIKey{
+		ID:        id,
+		Hash:      hash,
+		Owner:     "owner" + id,
+		UserID:    "user" + id,
+		Scopes:    []string{"create", "read-stats"},
+		CreatedAt: time.Now().Truncate(time.Millisecond),
+	}
+	if err := st.CreateAPIKey(ctx, key); err != nil {
+		return fmt.Errorf("CreateAPIKey: %w", err)
+	}
+	err := st.CreateAPIKey(ctx, APIKey{ID: id, Hash: []byte("other" + id), Owner: key.Owner, CreatedAt: key.CreatedAt})
+	if err := expectErr("CreateAPIKey with a taken id", err, ErrAPIKeyAlreadyExists); err != nil {
+		return err
+	}
+	err = st.CreateAPIKey(ctx, APIKey{ID: unique(), Hash: hash, Owner: key.Owner, CreatedAt: key.CreatedAt})
+	if err := expectErr("CreateAPIKey with a taken hash", err, ErrAPIKeyAlreadyExists); err != nil {
+		return err
+	}
+
+	got, err := st.GetAPIKey(ctx, hash)
+	if err != nil {
+		return fmt.Errorf("GetAPIKey: %w", err)
+	}
+	if got.ID != id || got.Owner != key.Owner || got.UserID != key.UserID || fmt.Sprint(got.Scopes) != fmt.Sprint(key.Scopes) ||
+		!got.CreatedAt.Equal(key.CreatedAt) || got.Revoked() {
+		return fmt.Errorf("GetAPIKey = %+v, want %+v", got, key)
+	}
+	_, err = st.GetAPIKey(ctx, []byte("unknown"+id))
+	if err := expectErr("GetAPIKey of an unknown hash", err, ErrAPIKeyNotFound); err != nil {
+		return err
+	}
+
+	revokedAt := time.Now().Truncate(time.Millisecond)
+	if err := st.RevokeAPIKey(ctx, id, revokedAt); err != nil {
+		return fmt.Errorf("RevokeAPIKey: %w", err)
+	}
+	if err := st.RevokeAPIKey(ctx, id, revokedAt.Add(time.Hour)); err != nil {
+		return fmt.Errorf("RevokeAPIKey twice: %w", err)
+	}
+	err = st.RevokeAPIKey(ctx, unique(), revokedAt)
+	if err := expectErr("RevokeAPIKey of an unknown id", err, ErrAPIKeyNotFound); err != nil {
+		return err
+	}
+	got, err = st.GetAPIKey(ctx, hash)
+	if err != nil {
+		return fmt.Errorf("GetAPIKey: %w", err)
+	}
+	if !got.RevokedAt.Equal(revokedAt) {
+		return fmt.Errorf("GetAPIKey revoked at %s, want %s", got.RevokedAt, revokedAt)
+	}
+
+	keys, err := st.ListAPIKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("ListAPIKeys: %w", err)
+	}
+	for i, k := range keys {
+		if i > 0 && k.CreatedAt.Before(keys[i-1].CreatedAt) {
+			return fmt.Errorf("ListAPIKeys is not ordered by creation")
+		}
+		if k.ID == id {
+			if !k.Revoked() || string(k.Hash) != string(hash) {
+				return fmt.Errorf("ListAPIKeys returned %+v, want it revoked with its hash", k)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("ListAPIKeys is missing the key %s", id)
+}
+
+// conformBatch creates links of every kind in one batch, the items failing on their own must not fail the others
+func conformBatch(ctx context.Context, st Store) error {
+	results, err := st.SetBatch(ctx, nil)
+	if err != nil || len(results) != 0 {
+		return fmt.Errorf("SetBatch of no items = %v, %v, want no results", results, err)
+	}
+
+	taken := uniqueAlias()
+	if _, err := st.Set(ctx, uniqueURL(), SetOptions{Alias: taken}); err != nil {
+		return fmt.Errorf("Set alias: %w", err)
+	}
+	shared, scope, alias, owner := uniqueURL(), "conformance"+unique(), uniqueAlias(), "owner"+unique()
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	items := []BatchItem{
+		{URL: uniqueURL()},
+		{URL: shared, Opts: SetOptions{DedupScope: scope}},
+		{URL: uniqueURL(), Opts: SetOptions{Alias: alias}},
+		{URL: uniqueURL(), Opts: SetOptions{Alias: taken}},
+		{URL: shared, Opts: SetOptions{DedupScope: scope}},
+		{URL: uniqueURL(), Opts: SetOptions{ExpiresAt: expiresAt, Owner: owner}},
+		{URL: uniqueURL(), Opts: SetOptions{Alias: alias}},
+	}
+	results, err = st.SetBatch(ctx, items)
+	if err != nil {
+		return fmt.Errorf("SetBatch: %w", err)
+	}
+	if len(results) != len(items) {
+		return fmt.Errorf("SetBatch returned %d results for %d items", len(results), len(items))
+	}
+
+	for _, i := range []int{3, 6} {
+		if err := expectErr(fmt.Sprintf("SetBatch item %d with a taken alias", i), results[i].Err, ErrKeyAlreadyExists); err != nil {
+			return err
+		}
+	}
+	if results[2].Key != alias {
+		return fmt.Errorf("SetBatch alias item returned %q, want %s", results[2].Key, alias)
+	}
+	if results[1].Key != results[4].Key {
+		return fmt.Errorf("SetBatch of the same url in the same scope returned %s and %s", results[1].Key, results[4].Key)
+	}
+	seen := make(map[string]bool)
+	for _, i := range []int{0, 1, 2, 5} {
+		res := results[i]
+		if res.Err != nil {
+			return fmt.Errorf("SetBatch item %d: %w", i, res.Err)
+		}
+		if seen[res.Key] {
+			return fmt.Errorf("SetBatch returned the key %s twice", res.Key)
+		}
+		seen[res.Key] = true
+		if err := expectURL(ctx, st, res.Key, items[i].URL); err != nil {
+			return err
+		}
+	}
+
+	link, err := st.GetLink(ctx, results[5].Key)
+	if err != nil {
+		return fmt.Errorf("GetLink(%s): %w", results[5].Key, err)
+	}
+	if !link.ExpiresAt.Equal(expiresAt) || link.Owner != owner {
+		return fmt.Errorf("GetLink(%s) expires at %s owned by %q, want %s and %q", link.Key, link.ExpiresAt, link.Owner, expiresAt, owner)
+	}
+
+	// The batch shares the links created before it too
+	key, err := st.Set(ctx, shared, SetOptions{DedupScope: scope})
+	if err != nil {
+		return fmt.Errorf("Set of a url of the batch: %w", err)
+	}
+	if key != results[1].Key {
+		return fmt.Errorf("Set of a url of the batch returned %s, want %s", key, results[1].Key)
+	}
+	results, err = st.SetBatch(ctx, []BatchItem{{URL: shared, Opts: SetOptions{DedupScope: scope}}})
+	if err != nil {
+		return fmt.Errorf("SetBatch: %w", err)
+	}
+	if results[0].Key != key {
+		return fmt.Errorf("SetBatch of a shared url returned %s, want %s", results[0].Key, key)
+	}
+	return nil
+}
+
+func conformConcurrentSet(ctx context.Context, st Store) error {
+	keys := make([]string, conformanceWorkers)
+	urls := make([]string, conformanceWorkers)
+	errs := make([]error, conformanceWorkers)
+	var wg sync.WaitGroup
+	for i := range keys {
+		urls[i] = uniqueURL()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			keys[i], errs[i] = st.Set(ctx, urls[i], SetOptions{})
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, key := range keys {
+		if errs[i] != nil {
+			return fmt.Errorf("Set: %w", errs[i])
+		}
+		if seen[key] {
+			return fmt.Errorf("Set returned the key %s for two urls", key)
+		}
+		seen[key] = true
+		if err := expectURL(ctx, st, key, urls[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// conformConcurrentAlias races for the same alias, exactly one Set must win
+func conformConcurrentAlias(ctx context.Context, st Store) error {
+	alias := uniqueAlias()
+	urls := make([]string, conformanceWorkers)
+	errs := make([]error, conformanceWorkers)
+	var wg sync.WaitGroup
+	for i := range urls {
+		urls[i] = uniqueURL()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = st.Set(ctx, urls[i], SetOptions{Alias: alias})
+		}(i)
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errs {
+		switch {
+		case err == nil && winner >= 0:
+			return fmt.Errorf("Set(alias=%s) succeeded more than once", alias)
+		case err == nil:
+			winner = i
+		case !errors.Is(err, ErrKeyAlreadyExists):
+			return fmt.Errorf("Set(alias=%s) returned %v, want nil or %v", alias, err, ErrKeyAlreadyExists)
+		}
+	}
+	if winner < 0 {
+		return fmt.Errorf("Set(alias=%s) never succeeded", alias)
+	}
+	return expectURL(ctx, st, alias, urls[winner])
+}
+
+func conformCanceledContext(ctx context.Context, st Store) error {
+	key, err := st.Set(ctx, uniqueURL(), SetOptions{})
+	if err != nil {
+		return fmt.Errorf("Set: %w", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = st.Get(canceled, key)
+	if err := expectErr("Get with a canceled context", err, context.Canceled); err != nil {
+		return err
+	}
+	_, err = st.Set(canceled, uniqueURL(), SetOptions{})
+	return expectErr("Set with a canceled context", err, context.Canceled)
+}
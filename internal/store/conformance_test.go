@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"github.com/kelseyhightower/envconfig"
+	generator "go-url-short/internal/shorten"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestKeyGenerator(t *testing.T, strategy string) generator.KeyGenerator {
+	gen, err := generator.NewKeyGenerator(&generator.KeyGeneratorConfig{Strategy: strategy, Length: 6}, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gen
+}
+
+// TestConformance runs the conformance suite against the stores that can be started locally,
+// PostgreSQL when DB_HOST is set and redis when REDIS_ADDR is set. Use a dedicated database, the suite purges every expired link.
+func TestConformance(t *testing.T) {
+	stores := []struct {
+		name string
+		open func(t *testing.T) Store
+	}{
+		{"inmem", func(t *testing.T) Store {
+			return NewInMemStore(nil, newTestKeyGenerator(t, generator.StrategyRandom))
+		}},
+		{"inmem persisted", func(t *testing.T) Store {
+			return NewInMemStore(&InMemConfig{Dir: t.TempDir()}, newTestKeyGenerator(t, generator.StrategyRandom))
+		}},
+		{"inmem sequential", func(t *testing.T) Store {
+			return NewInMemStore(nil, newTestKeyGenerator(t, generator.StrategySequential))
+		}},
+		{"sqlite", func(t *testing.T) Store {
+			return NewSQLiteStore(&SQLiteConfig{Path: filepath.Join(t.TempDir(), "conformance.db")}, newTestKeyGenerator(t, generator.StrategySnowflake))
+		}},
+		{"inmem with lru cache", func(t *testing.T) Store {
+			st := NewInMemStore(nil, newTestKeyGenerator(t, generator.StrategyRandom))
+			return NewLRUCache(st, &CacheConfig{Size: 16, TTL: time.Minute, NegativeTTL: time.Minute})
+		}},
+		{"inmem with bloom filter", func(t *testing.T) Store {
+			st := NewInMemStore(nil, newTestKeyGenerator(t, generator.StrategyRandom))
+			return NewBloomFilter(st, &BloomConfig{Capacity: 1000, FalsePositiveRate: 0.01}, false)
+		}},
+		{"postgres", func(t *testing.T) Store {
+			var config DatabaseConfig
+			if err := envconfig.Process("DB", &config); err != nil {
+				t.Fatal(err)
+			}
+			if config.Host == "" {
+				t.Skip("DB_HOST is not set")
+			}
+			return NewPostgresStore(&config, newTestKeyGenerator(t, generator.StrategySnowflake))
+		}},
+		{"redis", func(t *testing.T) Store {
+			var config RedisConfig
+			if err := envconfig.Process("REDIS", &config); err != nil {
+				t.Fatal(err)
+			}
+			if config.Addr == "" {
+				t.Skip("REDIS_ADDR is not set")
+			}
+			st, err := NewRedisStore(&config, newTestKeyGenerator(t, generator.StrategyRandom))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return st
+		}},
+	}
+
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			st := s.open(t)
+			defer st.DbClose(context.Background())
+			if err := CheckConformance(context.Background(), st); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -4,3 +4,14 @@ import "errors"
 
 var ErrKeyAlreadyExists = errors.New("key already exists")
 var ErrKeyNotFound = errors.New("key not found")
+var ErrKeyExpired = errors.New("key expired")
+var ErrInvalidCursor = errors.New("invalid cursor")
+var ErrKeyGenerationFailed = errors.New("key generation failed")
+var ErrAPIKeyNotFound = errors.New("api key not found")
+var ErrAPIKeyAlreadyExists = errors.New("api key already exists")
+var ErrUserNotFound = errors.New("user not found")
+var ErrWorkspaceNotFound = errors.New("workspace not found")
+var ErrMemberNotFound = errors.New("member not found")
+var ErrAlreadyExists = errors.New("already exists")
+var ErrLastOwner = errors.New("last owner of the workspace")
+var ErrCorruptData = errors.New("corrupt persisted data")
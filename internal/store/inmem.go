@@ -1,50 +1,588 @@
 package store
 
 import (
+	"context"
 	generator "go-url-short/internal/shorten"
 	"log"
+	"sort"
+	"sync"
+	"time"
 )
 
+type InMemConfig struct {
+	Dir              string        `default:"" desc:"Directory persisting the in-memory store, empty keeps it in memory only"`
+	SnapshotInterval time.Duration `default:"5m" desc:"How often the log of the in-memory store is compacted into a snapshot"`
+}
+
+// InMemStore is safe for concurrent use.
+// With a directory configured every change is appended to a log that is replayed on startup.
 type InMemStore struct {
-	urls map[string]string
-	Log  *log.Logger
+	mu sync.RWMutex
+	*inmemData
+	// dedup maps the dedup hashes to the key of the link shared for them
+	dedup map[string]string
+	// keyHashes maps the hashes of the API keys to their ID
+	keyHashes    map[string]string
+	gen          generator.KeyGenerator
+	persist      *persistence
+	stopSnapshot chan struct{}
+	Log          *log.Logger
 }
 
-func NewInMemStore() *InMemStore {
+func NewInMemStore(config *InMemConfig, gen generator.KeyGenerator) *InMemStore {
 	l := log.New(log.Writer(), "INMEMSTORE:", log.LstdFlags)
 	log.Println("Creating new in-memory store")
-	return &InMemStore{
-		urls: make(map[string]string),
-		Log:  l,
+	s := &InMemStore{
+		inmemData: newInmemData(),
+		dedup:     make(map[string]string),
+		keyHashes: make(map[string]string),
+		gen:       gen,
+		Log:       l,
+	}
+	if config == nil || config.Dir == "" {
+		return s
+	}
+
+	p, data, err := openPersistence(config.Dir, l)
+	if err != nil {
+		panic(err)
+	}
+	l.Printf("Loaded %d links, %d api keys, %d users and %d workspaces from %s",
+		len(data.urls), len(data.keys), len(data.users), len(data.workspaces), config.Dir)
+	s.inmemData, s.persist = data, p
+	for k, link := range s.urls {
+		if link.dedupHash != "" {
+			s.dedup[link.dedupHash] = k
+		}
 	}
+	for id, key := range s.keys {
+		s.keyHashes[string(key.Hash)] = id
+	}
+	if seeder, ok := gen.(generator.Seeder); ok {
+		seeder.Seed(lastGeneratedID(s.urls))
+	}
+
+	if config.SnapshotInterval > 0 {
+		s.stopSnapshot = make(chan struct{})
+		go s.snapshotLoop(config.SnapshotInterval)
+	}
+	return s
 }
-func (s InMemStore) DbClose() {
+
+// lastGeneratedID is the highest id of the links whose key was generated
+func lastGeneratedID(urls map[string]Link) int64 {
+	var last int64
+	for shortKey, link := range urls {
+		if link.alias {
+			continue
+		}
+		if id, err := generator.ConvertRadix10(shortKey); err == nil && id > last {
+			last = id
+		}
+	}
+	return last
+}
+
+func (s *InMemStore) snapshotLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopSnapshot:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			err := s.persist.snapshot(s.inmemData)
+			s.mu.Unlock()
+			if err != nil {
+				s.Log.Println("Error writing snapshot: ", err)
+			}
+		}
+	}
+}
+
+func (s *InMemStore) DbClose(ctx context.Context) {
 	s.Log.Println("Closing database connection")
-	s.urls = make(map[string]string)
+	if s.stopSnapshot != nil {
+		close(s.stopSnapshot)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.persist != nil {
+		if err := s.persist.snapshot(s.inmemData); err != nil {
+			s.Log.Println("Error writing snapshot: ", err)
+		}
+		s.persist.close()
+		s.persist = nil
+	}
+	s.inmemData = newInmemData()
+	s.dedup = make(map[string]string)
+	s.keyHashes = make(map[string]string)
+}
+
+// write records the change in the log before it is applied to the map, it must be called with the lock held
+func (s *InMemStore) write(entry logEntry) error {
+	if s.persist == nil {
+		return nil
+	}
+	if err := s.persist.append(entry); err != nil {
+		s.Log.Println("Error appending to log: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *InMemStore) Get(ctx context.Context, shortKey string) (string, error) {
+	link, err := s.GetLink(ctx, shortKey)
+	if err != nil {
+		return "", err
+	}
+
+	if link.Expired(time.Now()) {
+		return "", ErrKeyExpired
+	}
+
+	return link.URL, nil
+}
+
+func (s *InMemStore) GetLink(ctx context.Context, shortKey string) (Link, error) {
+	if err := ctx.Err(); err != nil {
+		return Link{}, err
+	}
+
+	s.mu.RLock()
+	link, found := s.urls[shortKey]
+	s.mu.RUnlock()
+	if !found {
+		return Link{}, ErrKeyNotFound
+	}
+
+	return link, nil
+}
+
+func (s *InMemStore) Set(ctx context.Context, originalURL string, opts SetOptions) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if opts.Alias != "" {
+		if _, found := s.urls[opts.Alias]; found {
+			return "", ErrKeyAlreadyExists
+		}
+		if err := s.put(opts.Alias, originalURL, opts); err != nil {
+			return "", err
+		}
+		return opts.Alias, nil
+	}
+
+	if hash := opts.dedupHash(originalURL); hash != nil {
+		if shortKey, found := s.dedup[string(hash)]; found {
+			return shortKey, nil
+		}
+	}
+
+	if grower, ok := s.gen.(generator.Grower); ok {
+		grower.Grow(len(s.urls))
+	}
+
+	for attempt := 1; attempt <= maxSetAttempts; attempt++ {
+		id, err := nextID(s.gen, originalURL, attempt)
+		if err != nil {
+			s.Log.Println("Error generating key: ", err)
+			return "", err
+		}
+
+		shortKey := generator.ConvertRadix62(id)
+		if _, found := s.urls[shortKey]; found {
+			s.Log.Printf("Generated key(%s) already exists, attempt %d", shortKey, attempt)
+			continue
+		}
+
+		if err := s.put(shortKey, originalURL, opts); err != nil {
+			return "", err
+		}
+		return shortKey, nil
+	}
+
+	return "", ErrKeyGenerationFailed
+}
+
+func (s *InMemStore) SetBatch(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	return setEach(ctx, s.Set, items)
+}
+
+// put stores a new link, it must be called with the lock held
+func (s *InMemStore) put(shortKey string, originalURL string, opts SetOptions) error {
+	link := Link{
+		Key:       shortKey,
+		URL:       originalURL,
+		CreatedAt: time.Now(),
+		ExpiresAt: opts.ExpiresAt,
+		Owner:     opts.Owner,
+		dedupHash: string(opts.dedupHash(originalURL)),
+		alias:     opts.Alias != "",
+	}
+	if err := s.write(setEntry(link)); err != nil {
+		return err
+	}
+	s.urls[shortKey] = link
+	if link.dedupHash != "" {
+		s.dedup[link.dedupHash] = shortKey
+	}
+	return nil
+}
+
+// remove deletes the link from the maps, it must be called with the lock held
+func (s *InMemStore) remove(shortKey string) {
+	if link := s.urls[shortKey]; link.dedupHash != "" {
+		delete(s.dedup, link.dedupHash)
+	}
+	delete(s.urls, shortKey)
+}
+
+func (s *InMemStore) Update(ctx context.Context, shortKey string, originalURL string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, found := s.urls[shortKey]
+	if !found {
+		return ErrKeyNotFound
+	}
+
+	// The link no longer points at the url it was shared for
+	shared := link.dedupHash
+	link.URL, link.dedupHash = originalURL, ""
+	if err := s.write(setEntry(link)); err != nil {
+		return err
+	}
+	if shared != "" {
+		delete(s.dedup, shared)
+	}
+	s.urls[shortKey] = link
+	return nil
+}
+
+func (s *InMemStore) Delete(ctx context.Context, shortKey string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.urls[shortKey]; !found {
+		return ErrKeyNotFound
+	}
+
+	if err := s.write(deleteEntry(shortKey)); err != nil {
+		return err
+	}
+	s.remove(shortKey)
+	return nil
+}
+
+// List pages through the links ordered by key, the cursor is the last key of the previous page
+func (s *InMemStore) List(ctx context.Context, cursor string, limit int) ([]Link, string, error) {
+	return s.list(ctx, cursor, limit, func(Link) bool { return true })
+}
+
+func (s *InMemStore) ListByOwner(ctx context.Context, owner string, cursor string, limit int) ([]Link, string, error) {
+	return s.list(ctx, cursor, limit, func(link Link) bool { return link.Owner == owner })
+}
+
+func (s *InMemStore) list(ctx context.Context, cursor string, limit int, match func(Link) bool) ([]Link, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.urls))
+	for k, link := range s.urls {
+		if k > cursor && match(link) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	next := ""
+	if len(keys) > limit {
+		keys = keys[:limit]
+		next = keys[limit-1]
+	}
+
+	links := make([]Link, 0, len(keys))
+	for _, k := range keys {
+		links = append(links, s.urls[k])
+	}
+	return links, next, nil
+}
+
+func (s *InMemStore) PurgeExpired(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for k, link := range s.urls {
+		if link.Expired(before) {
+			if err := s.write(deleteEntry(k)); err != nil {
+				return n, err
+			}
+			s.remove(k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *InMemStore) CreateAPIKey(ctx context.Context, key APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.keys[key.ID]; found {
+		return ErrAPIKeyAlreadyExists
+	}
+	if _, found := s.keyHashes[string(key.Hash)]; found {
+		return ErrAPIKeyAlreadyExists
+	}
+
+	if err := s.write(apiKeyEntry(key)); err != nil {
+		return err
+	}
+	s.keys[key.ID] = key
+	s.keyHashes[string(key.Hash)] = key.ID
+	return nil
+}
+
+func (s *InMemStore) GetAPIKey(ctx context.Context, hash []byte) (APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return APIKey{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, found := s.keyHashes[string(hash)]
+	if !found {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	return s.keys[id], nil
 }
 
-func (s InMemStore) Get(shortKey string) (string, error) {
-	var originalURL string
-	var found bool
+func (s *InMemStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	if originalURL, found = s.urls[shortKey]; !found {
-		return "", ErrKeyNotFound
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
 	}
+	sortAPIKeys(keys)
+	return keys, nil
+}
 
-	return originalURL, nil
+func (s *InMemStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, found := s.keys[id]
+	if !found {
+		return ErrAPIKeyNotFound
+	}
+	if key.Revoked() {
+		return nil
+	}
+
+	key.RevokedAt = at
+	if err := s.write(apiKeyEntry(key)); err != nil {
+		return err
+	}
+	s.keys[id] = key
+	return nil
 }
 
-func (s InMemStore) Set(originalURL string) (string, error) {
-	shortKey := generator.GenerateRandomKey()
+func (s *InMemStore) CreateUser(ctx context.Context, user User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.users[user.ID]; found {
+		return ErrAlreadyExists
+	}
+
+	if err := s.write(userEntry(user)); err != nil {
+		return err
+	}
+	s.users[user.ID] = user
+	return nil
+}
 
-	if shortKey, found := s.urls[shortKey]; found {
-		return shortKey, ErrKeyAlreadyExists
+func (s *InMemStore) ListUsers(ctx context.Context) ([]User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	if _, err := s.Get(shortKey); err == nil {
-		return "", ErrKeyAlreadyExists
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
 	}
+	sortUsers(users)
+	return users, nil
+}
 
-	s.urls[shortKey] = originalURL
-	return shortKey, nil
+func (s *InMemStore) CreateWorkspace(ctx context.Context, workspace Workspace, first Member) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.workspaces[workspace.ID]; found {
+		return ErrAlreadyExists
+	}
+	if _, found := s.users[first.UserID]; !found {
+		return ErrUserNotFound
+	}
+
+	first.WorkspaceID = workspace.ID
+	if err := s.write(workspaceEntry(workspace)); err != nil {
+		return err
+	}
+	s.workspaces[workspace.ID] = workspace
+	if err := s.write(memberEntry(opMember, first)); err != nil {
+		return err
+	}
+	s.members[memberKey(first.WorkspaceID, first.UserID)] = first
+	return nil
+}
+
+func (s *InMemStore) ListWorkspaces(ctx context.Context) ([]Workspace, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	workspaces := make([]Workspace, 0, len(s.workspaces))
+	for _, workspace := range s.workspaces {
+		workspaces = append(workspaces, workspace)
+	}
+	sortWorkspaces(workspaces)
+	return workspaces, nil
+}
+
+func (s *InMemStore) SetMember(ctx context.Context, member Member) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.workspaces[member.WorkspaceID]; !found {
+		return ErrWorkspaceNotFound
+	}
+	if _, found := s.users[member.UserID]; !found {
+		return ErrUserNotFound
+	}
+	if member.Role != RoleOwner && s.lastOwner(member.WorkspaceID, member.UserID) {
+		return ErrLastOwner
+	}
+
+	if err := s.write(memberEntry(opMember, member)); err != nil {
+		return err
+	}
+	s.members[memberKey(member.WorkspaceID, member.UserID)] = member
+	return nil
+}
+
+func (s *InMemStore) GetMember(ctx context.Context, workspaceID string, userID string) (Member, error) {
+	if err := ctx.Err(); err != nil {
+		return Member{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	member, found := s.members[memberKey(workspaceID, userID)]
+	if !found {
+		return Member{}, ErrMemberNotFound
+	}
+	return member, nil
+}
+
+func (s *InMemStore) ListMembers(ctx context.Context, workspaceID string) ([]Member, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, found := s.workspaces[workspaceID]; !found {
+		return nil, ErrWorkspaceNotFound
+	}
+	members := []Member{}
+	for _, member := range s.members {
+		if member.WorkspaceID == workspaceID {
+			members = append(members, member)
+		}
+	}
+	sortMembers(members)
+	return members, nil
+}
+
+func (s *InMemStore) RemoveMember(ctx context.Context, workspaceID string, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	member, found := s.members[memberKey(workspaceID, userID)]
+	if !found {
+		return ErrMemberNotFound
+	}
+	if s.lastOwner(workspaceID, userID) {
+		return ErrLastOwner
+	}
+
+	if err := s.write(memberEntry(opRemoveMember, member)); err != nil {
+		return err
+	}
+	delete(s.members, memberKey(workspaceID, userID))
+	return nil
+}
+
+// lastOwner reports whether the user is the only owner of the workspace, s.mu must be held
+func (s *InMemStore) lastOwner(workspaceID string, userID string) bool {
+	if s.members[memberKey(workspaceID, userID)].Role != RoleOwner {
+		return false
+	}
+	for _, member := range s.members {
+		if member.WorkspaceID == workspaceID && member.UserID != userID && member.Role == RoleOwner {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,283 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	snapshotFile = "snapshot.jsonl"
+	logFile      = "log.jsonl"
+
+	opSet    = "set"
+	opDelete = "delete"
+	opAPIKey = "apikey"
+
+	opUser         = "user"
+	opWorkspace    = "workspace"
+	opMember       = "member"
+	opRemoveMember = "remove_member"
+)
+
+// inmemData is everything the in-memory store keeps, and persists when it has a directory
+type inmemData struct {
+	urls       map[string]Link
+	keys       map[string]APIKey
+	users      map[string]User
+	workspaces map[string]Workspace
+	// members are keyed by memberKey
+	members map[string]Member
+}
+
+func newInmemData() *inmemData {
+	return &inmemData{
+		urls:       make(map[string]Link),
+		keys:       make(map[string]APIKey),
+		users:      make(map[string]User),
+		workspaces: make(map[string]Workspace),
+		members:    make(map[string]Member),
+	}
+}
+
+func memberKey(workspaceID string, userID string) string {
+	return workspaceID + "\x00" + userID
+}
+
+// logEntry is a line of the snapshot and of the append-only log
+type logEntry struct {
+	Op        string     `json:"op"`
+	Key       string     `json:"key"`
+	URL       string     `json:"url,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Dedup     []byte     `json:"dedup,omitempty"`
+	Owner     string     `json:"owner,omitempty"`
+	Alias     bool       `json:"alias,omitempty"`
+	// APIKey is the whole key of the apikey entries, which are written on creation and on revocation
+	APIKey *APIKey `json:"api_key,omitempty"`
+	// User, Workspace and Member are the whole entity of their entries, removals only carry the Member
+	User      *User      `json:"user,omitempty"`
+	Workspace *Workspace `json:"workspace,omitempty"`
+	Member    *Member    `json:"member,omitempty"`
+}
+
+func setEntry(link Link) logEntry {
+	e := logEntry{Op: opSet, Key: link.Key, URL: link.URL, CreatedAt: &link.CreatedAt, Owner: link.Owner, Alias: link.alias}
+	if !link.ExpiresAt.IsZero() {
+		e.ExpiresAt = &link.ExpiresAt
+	}
+	if link.dedupHash != "" {
+		e.Dedup = []byte(link.dedupHash)
+	}
+	return e
+}
+
+func deleteEntry(shortKey string) logEntry {
+	return logEntry{Op: opDelete, Key: shortKey}
+}
+
+func apiKeyEntry(key APIKey) logEntry {
+	return logEntry{Op: opAPIKey, Key: key.ID, APIKey: &key}
+}
+
+func userEntry(user User) logEntry {
+	return logEntry{Op: opUser, Key: user.ID, User: &user}
+}
+
+func workspaceEntry(workspace Workspace) logEntry {
+	return logEntry{Op: opWorkspace, Key: workspace.ID, Workspace: &workspace}
+}
+
+func memberEntry(op string, member Member) logEntry {
+	return logEntry{Op: op, Key: memberKey(member.WorkspaceID, member.UserID), Member: &member}
+}
+
+func (e logEntry) apply(d *inmemData) error {
+	switch e.Op {
+	case opSet:
+		link := Link{Key: e.Key, URL: e.URL, Owner: e.Owner, dedupHash: string(e.Dedup), alias: e.Alias}
+		if e.CreatedAt != nil {
+			link.CreatedAt = *e.CreatedAt
+		}
+		if e.ExpiresAt != nil {
+			link.ExpiresAt = *e.ExpiresAt
+		}
+		d.urls[e.Key] = link
+	case opDelete:
+		delete(d.urls, e.Key)
+	case opAPIKey:
+		if e.APIKey == nil {
+			return fmt.Errorf("missing api key of log entry %s", e.Key)
+		}
+		d.keys[e.Key] = *e.APIKey
+	case opUser, opWorkspace, opMember, opRemoveMember:
+		return e.applyAccount(d)
+	default:
+		return fmt.Errorf("unknown log operation: %s", e.Op)
+	}
+	return nil
+}
+
+func (e logEntry) applyAccount(d *inmemData) error {
+	switch {
+	case e.Op == opUser && e.User != nil:
+		d.users[e.Key] = *e.User
+	case e.Op == opWorkspace && e.Workspace != nil:
+		d.workspaces[e.Key] = *e.Workspace
+	case e.Op == opMember && e.Member != nil:
+		d.members[e.Key] = *e.Member
+	case e.Op == opRemoveMember:
+		delete(d.members, e.Key)
+	default:
+		return fmt.Errorf("missing %s of log entry %s", e.Op, e.Key)
+	}
+	return nil
+}
+
+// persistence keeps the in-memory store on disk as a snapshot plus an append-only log of the changes made since.
+// The log is not synced on every write, a process crash loses nothing but a machine crash can lose the latest changes.
+type persistence struct {
+	dir string
+	log *os.File
+	enc *json.Encoder
+}
+
+// openPersistence loads the data of the snapshot, replays the log over it and opens the log for appending.
+// It returns ErrCorruptData when a file has an unreadable line other than a torn last line of the log,
+// the files are then left as they are instead of losing the entries after that line.
+func openPersistence(dir string, l *log.Logger) (*persistence, *inmemData, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+
+	d := newInmemData()
+	// The snapshot is renamed into place once complete, it never has a torn line
+	if _, err := replay(filepath.Join(dir, snapshotFile), d, l, false); err != nil {
+		return nil, nil, err
+	}
+	size, err := replay(filepath.Join(dir, logFile), d, l, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	// A torn last line is cut off, the next entries would be glued to it and lost on the next replay otherwise
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return &persistence{dir: dir, log: f, enc: json.NewEncoder(f)}, d, nil
+}
+
+// replay applies the entries of the file to the data and returns the size of the entries read,
+// a torn last line is only skipped when the file may have one
+func replay(path string, d *inmemData, l *log.Logger, torn bool) (int64, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var size int64
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if err == io.EOF && len(b) == 0 {
+			return size, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		// Only the last line can be torn by a crash in the middle of a write, it lacks its newline when it is
+		if err == io.EOF && torn {
+			l.Printf("Ignoring the torn line %d of %s", line, path)
+			return size, nil
+		}
+		if err == io.EOF {
+			return 0, fmt.Errorf("%w: line %d of %s has no newline", ErrCorruptData, line, path)
+		}
+		var e logEntry
+		if err := json.Unmarshal(b, &e); err != nil {
+			return 0, fmt.Errorf("%w: line %d of %s: %v", ErrCorruptData, line, path, err)
+		}
+		if err := e.apply(d); err != nil {
+			return 0, fmt.Errorf("%w: line %d of %s: %v", ErrCorruptData, line, path, err)
+		}
+		size += int64(len(b))
+	}
+}
+
+func (p *persistence) append(e logEntry) error {
+	return p.enc.Encode(e)
+}
+
+// snapshot atomically replaces the snapshot with the given data and empties the log
+func (p *persistence) snapshot(d *inmemData) error {
+	tmp, err := os.CreateTemp(p.dir, snapshotFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	encode := func(e logEntry) {
+		if err == nil {
+			err = enc.Encode(e)
+		}
+	}
+	for _, link := range d.urls {
+		encode(setEntry(link))
+	}
+	for _, key := range d.keys {
+		encode(apiKeyEntry(key))
+	}
+	for _, user := range d.users {
+		encode(userEntry(user))
+	}
+	for _, workspace := range d.workspaces {
+		encode(workspaceEntry(workspace))
+	}
+	for _, member := range d.members {
+		encode(memberEntry(opMember, member))
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(p.dir, snapshotFile)); err != nil {
+		return err
+	}
+
+	// The log is appended to, so writes after the truncation start over at its beginning
+	return p.log.Truncate(0)
+}
+
+func (p *persistence) close() error {
+	return p.log.Close()
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenPersistence(t *testing.T) {
+	set := func(key string) string {
+		return `{"op":"set","key":"` + key + `","url":"https://example.com/` + key + `"}` + "\n"
+	}
+
+	for _, c := range []struct {
+		name     string
+		snapshot string
+		log      string
+		// keys are the links loaded, nil when the files are corrupt
+		keys []string
+	}{
+		{"empty", "", "", []string{}},
+		{"snapshot and log", set("a"), set("b") + `{"op":"delete","key":"a"}` + "\n", []string{"b"}},
+		{"torn last line of the log", set("a"), set("b") + `{"op":"set","ke`, []string{"a", "b"}},
+		{"unreadable line in the log", "", set("a") + "garbage\n" + set("b"), nil},
+		{"unreadable last line of the log", "", set("a") + "garbage\n", nil},
+		{"unknown operation in the log", "", `{"op":"rename","key":"a"}` + "\n", nil},
+		{"unreadable line in the snapshot", set("a") + "garbage\n" + set("b"), "", nil},
+		{"torn last line of the snapshot", set("a") + `{"op":"set","ke`, "", nil},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range map[string]string{snapshotFile: c.snapshot, logFile: c.log} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			p, d, err := openPersistence(dir, log.Default())
+			if c.keys == nil {
+				if !errors.Is(err, ErrCorruptData) {
+					t.Fatalf("got %v, want ErrCorruptData", err)
+				}
+				// The entries after the unreadable line must still be there to be repaired
+				for name, content := range map[string]string{snapshotFile: c.snapshot, logFile: c.log} {
+					if b, _ := os.ReadFile(filepath.Join(dir, name)); string(b) != content {
+						t.Errorf("%s was changed to %q", name, b)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(d.urls) != len(c.keys) {
+				t.Errorf("loaded %d links, want %v", len(d.urls), c.keys)
+			}
+			for _, key := range c.keys {
+				if d.urls[key].URL != "https://example.com/"+key {
+					t.Errorf("link %s was not loaded", key)
+				}
+			}
+
+			// A torn line is cut off, the entries appended afterwards must not be glued to it
+			if err := p.append(setEntry(Link{Key: "c", URL: "https://example.com/c"})); err != nil {
+				t.Fatal(err)
+			}
+			p.close()
+			p, d, err = openPersistence(dir, log.Default())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.close()
+			if d.urls["c"].URL != "https://example.com/c" || len(d.urls) != len(c.keys)+1 {
+				t.Errorf("reopened with %d links, want %v and c", len(d.urls), c.keys)
+			}
+		})
+	}
+}
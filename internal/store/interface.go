@@ -1,10 +1,64 @@
 package store
 
+import (
+	"context"
+	"time"
+)
+
 type Store interface {
-	// Get returns the original URL for the given short key
-	Get(shortKey string) (string, error)
-	// Set saves the original URL and returns the short key
-	Set(originalURL string) (string, error)
+	// Get returns the original URL for the given short key.
+	// It returns ErrKeyExpired when the link has expired but has not been purged yet.
+	Get(ctx context.Context, shortKey string) (string, error)
+	// GetLink returns the link stored under the given short key, even when it has expired
+	GetLink(ctx context.Context, shortKey string) (Link, error)
+	// Set saves the original URL and returns the short key.
+	// It returns ErrKeyAlreadyExists when the requested alias is already taken.
+	Set(ctx context.Context, originalURL string, opts SetOptions) (string, error)
+	// SetBatch saves the links of the items like Set and returns their results in the same order.
+	// ErrKeyAlreadyExists and ErrKeyGenerationFailed only fail their item, any other error fails the batch:
+	// PostgresStore then saves none of the links, the other stores keep the ones saved before the error
+	// and return their results along with it.
+	SetBatch(ctx context.Context, items []BatchItem) ([]BatchResult, error)
+	// Update points an existing short key at a new original URL
+	Update(ctx context.Context, shortKey string, originalURL string) error
+	// Delete removes the short key
+	Delete(ctx context.Context, shortKey string) error
+	// List returns up to limit links stored after the cursor and the cursor of the next page.
+	// An empty cursor starts from the beginning, an empty next cursor means there are no more pages.
+	List(ctx context.Context, cursor string, limit int) ([]Link, string, error)
+	// ListByOwner pages through the links of the owner like List, the empty owner lists the anonymous links
+	ListByOwner(ctx context.Context, owner string, cursor string, limit int) ([]Link, string, error)
+	// PurgeExpired deletes the links that expired before the given time and returns how many were deleted
+	PurgeExpired(ctx context.Context, before time.Time) (int64, error)
+	// CreateAPIKey saves a new API key.
+	// It returns ErrAPIKeyAlreadyExists when its ID or its hash is already taken.
+	CreateAPIKey(ctx context.Context, key APIKey) error
+	// GetAPIKey returns the API key with the given hash, even when it has been revoked
+	GetAPIKey(ctx context.Context, hash []byte) (APIKey, error)
+	// ListAPIKeys returns every API key ordered by creation
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	// RevokeAPIKey marks the API key as revoked, a key revoked twice keeps the first revocation time
+	RevokeAPIKey(ctx context.Context, id string, at time.Time) error
+	// CreateUser saves a new user, it returns ErrAlreadyExists when the ID is taken
+	CreateUser(ctx context.Context, user User) error
+	// ListUsers returns every user ordered by creation
+	ListUsers(ctx context.Context) ([]User, error)
+	// CreateWorkspace saves a new workspace along with its first member, whose WorkspaceID is the ID of the workspace.
+	// It returns ErrAlreadyExists when the ID is taken and ErrUserNotFound when the user does not exist.
+	CreateWorkspace(ctx context.Context, workspace Workspace, first Member) error
+	// ListWorkspaces returns every workspace ordered by creation
+	ListWorkspaces(ctx context.Context) ([]Workspace, error)
+	// SetMember adds the user to the workspace or changes its role.
+	// It returns ErrWorkspaceNotFound or ErrUserNotFound when either does not exist,
+	// and ErrLastOwner when it would take the RoleOwner from the last owner of the workspace.
+	SetMember(ctx context.Context, member Member) error
+	// GetMember returns the membership of the user in the workspace, ErrMemberNotFound when there is none
+	GetMember(ctx context.Context, workspaceID string, userID string) (Member, error)
+	// ListMembers returns the members of the workspace ordered by user ID, ErrWorkspaceNotFound when it does not exist
+	ListMembers(ctx context.Context, workspaceID string) ([]Member, error)
+	// RemoveMember removes the user from the workspace, ErrMemberNotFound when it is not a member
+	// and ErrLastOwner when it is the last owner of the workspace
+	RemoveMember(ctx context.Context, workspaceID string, userID string) error
 	// DbClose closes the database connection
-	DbClose()
+	DbClose(ctx context.Context)
 }
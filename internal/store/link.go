@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	generator "go-url-short/internal/shorten"
+	"time"
+)
+
+// DefaultListLimit is the page size used by List when no positive limit is given
+const DefaultListLimit = 20
+
+type Link struct {
+	Key       string
+	URL       string
+	CreatedAt time.Time
+	// ExpiresAt is zero for links that never expire
+	ExpiresAt time.Time
+	// Owner is the workspace owning the link, empty for anonymous links
+	Owner string
+	// dedupHash is set on the links shared by the later Set of the same url in the same scope
+	dedupHash string
+	// alias is set on the links of the in-memory store created with an alias, their keys are not generated ids
+	alias bool
+}
+
+// Expired reports whether the link has expired at the given time
+func (l Link) Expired(now time.Time) bool {
+	return !l.ExpiresAt.IsZero() && !now.Before(l.ExpiresAt)
+}
+
+// maxSetAttempts bounds how many generated keys Set tries before giving up on collisions
+const maxSetAttempts = 5
+
+// SetOptions are the optional settings of a new short link
+type SetOptions struct {
+	// Alias is a custom short key to use instead of a generated one
+	Alias string
+	// ExpiresAt is when the link stops redirecting, zero means never
+	ExpiresAt time.Time
+	// DedupScope shares the key of the link already created for the same url in the same scope by the same owner.
+	// Empty never shares, nor do links with an alias or an expiry.
+	DedupScope string
+	// Owner is recorded on the new link
+	Owner string
+}
+
+// dedupHash identifies the url within its dedup scope and its owner, nil when the link is never shared.
+// The owner keeps a workspace from getting back the key of another one, which it could not change
+// while the other one could point it elsewhere.
+func (o SetOptions) dedupHash(originalURL string) []byte {
+	if o.DedupScope == "" || o.Alias != "" || !o.ExpiresAt.IsZero() {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(o.DedupScope + "\x00" + o.Owner + "\x00" + originalURL))
+	return sum[:]
+}
+
+// BatchItem is a link to create with SetBatch
+type BatchItem struct {
+	URL  string
+	Opts SetOptions
+}
+
+// BatchResult is the outcome of an item of SetBatch, either its key or the error of its own Set
+type BatchResult struct {
+	Key string
+	Err error
+}
+
+// nextID returns the id of the attempt of Set, the retries of the generators that would
+// otherwise give the same id again get another one
+func nextID(gen generator.KeyGenerator, originalURL string, attempt int) (int64, error) {
+	if retrier, ok := gen.(generator.Retrier); ok && attempt > 1 {
+		return retrier.RetryID(originalURL, attempt)
+	}
+	return gen.NextID(originalURL)
+}
+
+// itemError reports whether the error of Set only fails its own item of a batch
+func itemError(err error) bool {
+	return errors.Is(err, ErrKeyAlreadyExists) || errors.Is(err, ErrKeyGenerationFailed)
+}
+
+// setEach creates the items of a batch one Set at a time, for the stores without a batch insert.
+// It returns the results of the items saved before an error failing the batch.
+func setEach(ctx context.Context, set func(ctx context.Context, originalURL string, opts SetOptions) (string, error), items []BatchItem) ([]BatchResult, error) {
+	results := make([]BatchResult, len(items))
+	for i, item := range items {
+		key, err := set(ctx, item.URL, item.Opts)
+		if err != nil && !itemError(err) {
+			return results[:i], err
+		}
+		results[i] = BatchResult{Key: key, Err: err}
+	}
+	return results, nil
+}
@@ -0,0 +1,175 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type CacheConfig struct {
+	Size        int           `default:"0" desc:"Number of keys kept in the in-process cache, 0 disables it"`
+	TTL         time.Duration `default:"1m" desc:"How long a link stays in the in-process cache"`
+	NegativeTTL time.Duration `default:"10s" desc:"How long an unknown key is remembered as missing"`
+}
+
+type lruEntry struct {
+	key  string
+	link Link
+	// err is ErrKeyNotFound for the keys cached as missing
+	err     error
+	expires time.Time
+}
+
+// LRUCache keeps the most recently resolved links of another store in memory.
+// Unknown keys are cached too so that repeated misses do not reach the store.
+// Updates and deletes only invalidate the cache of this process, other instances serve the old link until the TTL.
+type LRUCache struct {
+	Store
+	mu          sync.Mutex
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	// order has the most recently used entry at the front
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func NewLRUCache(st Store, config *CacheConfig) *LRUCache {
+	return &LRUCache{
+		Store:       st,
+		size:        config.Size,
+		ttl:         config.TTL,
+		negativeTTL: config.NegativeTTL,
+		order:       list.New(),
+		entries:     make(map[string]*list.Element, config.Size),
+	}
+}
+
+func (c *LRUCache) Get(ctx context.Context, shortKey string) (string, error) {
+	link, err := c.GetLink(ctx, shortKey)
+	if err != nil {
+		return "", err
+	}
+
+	if link.Expired(time.Now()) {
+		return "", ErrKeyExpired
+	}
+
+	return link.URL, nil
+}
+
+func (c *LRUCache) GetLink(ctx context.Context, shortKey string) (Link, error) {
+	if err := ctx.Err(); err != nil {
+		return Link{}, err
+	}
+
+	if entry, found := c.lookup(shortKey); found {
+		return entry.link, entry.err
+	}
+
+	link, err := c.Store.GetLink(ctx, shortKey)
+	switch {
+	case err == nil:
+		c.add(shortKey, link, nil, c.ttl)
+	case err == ErrKeyNotFound:
+		c.add(shortKey, Link{}, err, c.negativeTTL)
+	}
+	return link, err
+}
+
+func (c *LRUCache) lookup(shortKey string) (*lruEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, found := c.entries[shortKey]
+	if !found {
+		return nil, false
+	}
+
+	entry := elem.Value.(*lruEntry)
+	if !time.Now().Before(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, shortKey)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry, true
+}
+
+func (c *LRUCache) add(shortKey string, link Link, err error, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &lruEntry{key: shortKey, link: link, err: err, expires: time.Now().Add(ttl)}
+	if elem, found := c.entries[shortKey]; found {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[shortKey] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (c *LRUCache) invalidate(shortKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, found := c.entries[shortKey]; found {
+		c.order.Remove(elem)
+		delete(c.entries, shortKey)
+	}
+}
+
+func (c *LRUCache) Set(ctx context.Context, originalURL string, opts SetOptions) (string, error) {
+	shortKey, err := c.Store.Set(ctx, originalURL, opts)
+	if err != nil {
+		return "", err
+	}
+	// The new key may have been cached as missing
+	c.invalidate(shortKey)
+	return shortKey, nil
+}
+
+func (c *LRUCache) SetBatch(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	results, err := c.Store.SetBatch(ctx, items)
+	for _, res := range results {
+		if res.Err == nil {
+			c.invalidate(res.Key)
+		}
+	}
+	return results, err
+}
+
+func (c *LRUCache) Update(ctx context.Context, shortKey string, originalURL string) error {
+	err := c.Store.Update(ctx, shortKey, originalURL)
+	c.invalidate(shortKey)
+	return err
+}
+
+func (c *LRUCache) Delete(ctx context.Context, shortKey string) error {
+	err := c.Store.Delete(ctx, shortKey)
+	c.invalidate(shortKey)
+	return err
+}
+
+func (c *LRUCache) PurgeExpired(ctx context.Context, before time.Time) (int64, error) {
+	n, err := c.Store.PurgeExpired(ctx, before)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.entries {
+		if elem.Value.(*lruEntry).link.Expired(before) {
+			c.order.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,12 @@
+-- Tables created by the former hand-run db/db.sql used SERIAL ids that overflow with snowflake ids
+CREATE TABLE IF NOT EXISTS shorturl
+(
+    id         BIGINT PRIMARY KEY,
+    url        TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE shorturl ALTER COLUMN id DROP DEFAULT;
+ALTER TABLE shorturl ALTER COLUMN id TYPE BIGINT;
+ALTER TABLE shorturl ALTER COLUMN url TYPE TEXT;
+DROP SEQUENCE IF EXISTS shorturl_id_seq;
@@ -0,0 +1 @@
+ALTER TABLE shorturl DROP COLUMN alias;
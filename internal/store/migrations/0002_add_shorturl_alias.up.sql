@@ -0,0 +1,2 @@
+-- Custom aliases, generated keys keep it NULL
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS alias VARCHAR(64) UNIQUE;
@@ -0,0 +1,2 @@
+DROP INDEX shorturl_expires_at_idx;
+ALTER TABLE shorturl DROP COLUMN expires_at;
@@ -0,0 +1,3 @@
+-- NULL never expires
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS shorturl_expires_at_idx ON shorturl (expires_at) WHERE expires_at IS NOT NULL;
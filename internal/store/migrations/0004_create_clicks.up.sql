@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS clicks
+(
+    id         BIGSERIAL PRIMARY KEY,
+    short_key  VARCHAR(64) NOT NULL,
+    clicked_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    referrer   TEXT        NOT NULL DEFAULT '',
+    user_agent TEXT        NOT NULL DEFAULT '',
+    ip         VARCHAR(45) NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS clicks_short_key_clicked_at_idx ON clicks (short_key, clicked_at);
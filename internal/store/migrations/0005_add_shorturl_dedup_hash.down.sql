@@ -0,0 +1,2 @@
+DROP INDEX shorturl_dedup_hash_idx;
+ALTER TABLE shorturl DROP COLUMN dedup_hash;
@@ -0,0 +1,5 @@
+-- sha256 of the dedup scope, the owner and the normalized url separated by NUL bytes, NULL for the links that are never shared.
+-- The links created before are left NULL and never shared: their raw urls would not hash like the normalized ones.
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS dedup_hash BYTEA;
+
+CREATE UNIQUE INDEX IF NOT EXISTS shorturl_dedup_hash_idx ON shorturl (dedup_hash);
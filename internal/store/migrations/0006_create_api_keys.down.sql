@@ -0,0 +1,2 @@
+ALTER TABLE shorturl DROP COLUMN owner;
+DROP TABLE api_keys;
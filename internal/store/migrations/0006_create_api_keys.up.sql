@@ -0,0 +1,13 @@
+-- Only the sha256 of the secret of a key is stored, scopes are comma separated
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id         VARCHAR(32) PRIMARY KEY,
+    key_hash   BYTEA       NOT NULL UNIQUE,
+    owner      TEXT        NOT NULL,
+    scopes     TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMPTZ
+);
+
+-- Owner of the api key the link was created with, empty for the anonymous links
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT '';
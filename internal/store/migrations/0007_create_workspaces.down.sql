@@ -0,0 +1,5 @@
+DROP INDEX shorturl_owner_id_idx;
+ALTER TABLE api_keys DROP COLUMN user_id;
+DROP TABLE workspace_members;
+DROP TABLE workspaces;
+DROP TABLE users;
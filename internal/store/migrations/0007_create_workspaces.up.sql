@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS users
+(
+    id         VARCHAR(32) PRIMARY KEY,
+    name       TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The id of a workspace is the owner of its links and api keys
+CREATE TABLE IF NOT EXISTS workspaces
+(
+    id         VARCHAR(32) PRIMARY KEY,
+    name       TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS workspace_members
+(
+    workspace_id VARCHAR(32) NOT NULL REFERENCES workspaces (id) ON DELETE CASCADE,
+    user_id      VARCHAR(32) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role         TEXT        NOT NULL,
+    PRIMARY KEY (workspace_id, user_id)
+);
+
+-- Empty for the api keys issued before users existed
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS user_id VARCHAR(32) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS shorturl_owner_id_idx ON shorturl (owner, id);
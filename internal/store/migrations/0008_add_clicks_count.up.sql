@@ -0,0 +1,2 @@
+-- A row stands for count identical clicks of the same minute, the rows inserted before stand for one click each
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS count INTEGER NOT NULL DEFAULT 1;
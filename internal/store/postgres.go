@@ -1,11 +1,14 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	generator "go-url-short/internal/shorten"
 	"log"
+	"time"
 )
 
 type DatabaseConfig struct {
@@ -14,80 +17,772 @@ type DatabaseConfig struct {
 	Port     int    `default:""`
 	User     string `default:""`
 	Password string `default:""`
+	// AutoMigrate applies the pending migrations on startup instead of refusing to serve
+	AutoMigrate bool `default:"false"`
+}
+
+// ConnString returns the lib/pq connection string of the database
+func (c DatabaseConfig) ConnString() string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s",
+		c.Host, c.Port, c.User, c.Password, c.Name)
 }
 
 type PostgresStore struct {
 	db  *sql.DB
-	Log *log.Logger
+	gen generator.KeyGenerator
+	// aliasIds gives the aliased rows ids that never collide with the generated ones
+	aliasIds generator.KeyGenerator
+	Log      *log.Logger
 }
 
-func NewPostgresStore(config *DatabaseConfig) *PostgresStore {
+func NewPostgresStore(config *DatabaseConfig, gen generator.KeyGenerator) *PostgresStore {
 	l := log.New(log.Writer(), "POSTGRESSTORE:", log.LstdFlags)
 	l.Print("Conntected to postgres store")
-	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s",
-		config.Host, config.Port, config.User, config.Password, config.Name)
-	db, err := sql.Open("postgres", connStr)
+	db, err := sql.Open("postgres", config.ConnString())
+
+	if err != nil {
+		panic(err)
+	}
+
+	if err := checkSchema(db, config.AutoMigrate, l); err != nil {
+		panic(err)
+	}
 
+	aliasIds, err := generator.NewSnowflakeGenerator()
 	if err != nil {
 		panic(err)
 	}
 
+	if seeder, ok := gen.(generator.Seeder); ok {
+		var last int64
+		err := db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM shorturl WHERE alias IS NULL").Scan(&last)
+		if err != nil {
+			panic(err)
+		}
+		seeder.Seed(last)
+	}
+
 	return &PostgresStore{
-		Log: l,
-		db:  db,
+		Log:      l,
+		db:       db,
+		gen:      gen,
+		aliasIds: aliasIds,
+	}
+}
+
+// checkSchema makes sure the database schema is at least at the version of the embedded migrations
+func checkSchema(db *sql.DB, autoMigrate bool, l *log.Logger) error {
+	ctx := context.Background()
+	if autoMigrate {
+		applied, err := MigrateUp(ctx, db)
+		if err != nil {
+			return err
+		}
+		l.Printf("Applied %d migrations", applied)
 	}
+
+	current, err := SchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	latest, err := LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current < latest {
+		return fmt.Errorf("database schema version %d is behind %d, run the migrations first", current, latest)
+	}
+	return nil
 }
 
-func (s PostgresStore) DbClose() {
+func (s PostgresStore) DbClose(ctx context.Context) {
 	s.Log.Println("Closing database connection")
 	s.db.Close()
 }
 
-func (s PostgresStore) Get(shortKey string) (string, error) {
-	k, err := generator.ConvertRadix10(shortKey)
+// keyCondition matches a short key against custom aliases first and then against generated ids.
+// $1 is the short key and $2 its radix10 id.
+const keyCondition = "(alias = $1 OR (alias IS NULL AND id = $2))"
+
+func (s PostgresStore) Get(ctx context.Context, shortKey string) (string, error) {
+	link, err := s.GetLink(ctx, shortKey)
 	if err != nil {
-		s.Log.Println("Error converting radix62 to radix10: ", err)
+		return "", err
+	}
+
+	if link.Expired(time.Now()) {
+		return "", ErrKeyExpired
 	}
 
-	var url string
-	row := s.db.QueryRow("SELECT url FROM shorturl WHERE id = $1", k)
-	if err := row.Scan(&url); err != nil {
+	return link.URL, nil
+}
+
+func (s PostgresStore) GetLink(ctx context.Context, shortKey string) (Link, error) {
+	// Keys that are not radix62 ids can still be aliases
+	k, _ := generator.ConvertRadix10(shortKey)
+
+	link := Link{Key: shortKey}
+	var expiresAt sql.NullTime
+	row := s.db.QueryRowContext(ctx, `SELECT url, created_at, expires_at, owner FROM shorturl
+		WHERE `+keyCondition+` ORDER BY alias IS NULL LIMIT 1`, shortKey, k)
+	if err := row.Scan(&link.URL, &link.CreatedAt, &expiresAt, &link.Owner); err != nil {
 		s.Log.Println("Error querying database: ", err, k)
-		return "", ErrKeyNotFound
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return Link{}, ctxErr
+		}
+		return Link{}, ErrKeyNotFound
 	}
 
-	if err != nil && err == sql.ErrNoRows {
-		return "", ErrKeyNotFound
+	link.ExpiresAt = expiresAt.Time
+	return link, nil
+}
+
+func (s PostgresStore) Set(ctx context.Context, originalURL string, opts SetOptions) (string, error) {
+	if opts.Alias != "" {
+		return s.setAlias(ctx, originalURL, opts)
+	}
+
+	hash := opts.dedupHash(originalURL)
+	if hash != nil {
+		if key, err := s.dedupKey(ctx, hash); err != nil || key != "" {
+			return key, err
+		}
+	}
+
+	for attempt := 1; attempt <= maxSetAttempts; attempt++ {
+		newId, err := nextID(s.gen, originalURL, attempt)
+		if err != nil {
+			s.Log.Println("Error generating key: ", err)
+			return "", err
+		}
+
+		// The generated key must not be taken nor shadow a custom alias with the same name
+		var k int64
+		err = s.db.QueryRowContext(ctx, `INSERT INTO shorturl (id, url, expires_at, dedup_hash, owner) SELECT $1, $2, $4, $5, $6
+			WHERE NOT EXISTS (SELECT 1 FROM shorturl WHERE alias = $3)
+			ON CONFLICT DO NOTHING RETURNING id`,
+			newId, originalURL, generator.ConvertRadix62(newId), nullTime(opts.ExpiresAt), hash, opts.Owner).Scan(&k)
+		if err != nil && err == sql.ErrNoRows {
+			// The conflict may be the same url shared in the meantime rather than the key
+			if hash != nil {
+				if key, err := s.dedupKey(ctx, hash); err != nil || key != "" {
+					return key, err
+				}
+			}
+			s.Log.Printf("Generated key(%s) already exists, attempt %d", generator.ConvertRadix62(newId), attempt)
+			continue
+		}
+
+		if err != nil {
+			s.Log.Println("Error inserting into database: ", err)
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return "", ctxErr
+			}
+			return "", err
+		}
+
+		s.Log.Println("Inserted into database: ", k)
+		return generator.ConvertRadix62(k), nil
+	}
+
+	return "", ErrKeyGenerationFailed
+}
+
+// SetBatch inserts the links of every item with a single multi-row insert in a transaction.
+// Only the generated keys that collide are inserted again, each retry being another insert of the colliding items.
+func (s PostgresStore) SetBatch(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	results := make([]BatchResult, len(items))
+	if len(items) == 0 {
+		return results, nil
 	}
 
-	return url, nil
+	// The items with the dedup hash of an earlier item of the batch share its key
+	first := make(map[string]int)
+	shared := make(map[int]int)
+	pending := make([]int, 0, len(items))
+	for i, item := range items {
+		if hash := item.Opts.dedupHash(item.URL); hash != nil {
+			if j, found := first[string(hash)]; found {
+				shared[i] = j
+				continue
+			}
+			first[string(hash)] = i
+		}
+		pending = append(pending, i)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		s.Log.Println("Error beginning transaction: ", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for attempt := 1; len(pending) > 0; attempt++ {
+		if attempt > maxSetAttempts {
+			for _, i := range pending {
+				results[i].Err = ErrKeyGenerationFailed
+			}
+			break
+		}
+		if pending, err = s.insertBatch(ctx, tx, items, pending, results, attempt); err != nil {
+			s.Log.Println("Error inserting batch into database: ", err)
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.Log.Println("Error committing batch: ", err)
+		return nil, err
+	}
+	for i, j := range shared {
+		results[i] = results[j]
+	}
+	s.Log.Printf("Inserted batch of %d links into database", len(items))
+	return results, nil
 }
 
-func (s PostgresStore) Set(originalURL string) (string, error) {
-	// Check if the key already exists using orignalURL
+// insertBatch inserts the pending items in one statement and fills their results.
+// It returns the items whose generated key collided, to be inserted again with new keys.
+func (s PostgresStore) insertBatch(ctx context.Context, tx *sql.Tx, items []BatchItem, pending []int, results []BatchResult, attempt int) ([]int, error) {
+	n := len(pending)
+	ids, keyIds := make([]int64, n), make([]int64, n)
+	keys, urls, owners := make([]string, n), make([]string, n), make([]string, n)
+	aliases, expiresAt, hashes := make([]sql.NullString, n), make([]sql.NullString, n), make([]sql.NullString, n)
+	for p, i := range pending {
+		item := items[i]
+		urls[p], owners[p] = item.URL, item.Opts.Owner
+		if !item.Opts.ExpiresAt.IsZero() {
+			expiresAt[p] = sql.NullString{String: item.Opts.ExpiresAt.Format(time.RFC3339Nano), Valid: true}
+		}
+		if hash := item.Opts.dedupHash(item.URL); hash != nil {
+			hashes[p] = sql.NullString{String: hex.EncodeToString(hash), Valid: true}
+		}
+
+		gen := s.gen
+		if item.Opts.Alias != "" {
+			gen = s.aliasIds
+		}
+		id, err := nextID(gen, item.URL, attempt)
+		if err != nil {
+			return nil, err
+		}
+		ids[p] = id
+
+		// Aliases are checked like setAlias and generated keys like Set, see keyCondition
+		if item.Opts.Alias != "" {
+			keys[p], aliases[p] = item.Opts.Alias, sql.NullString{String: item.Opts.Alias, Valid: true}
+			keyIds[p], _ = generator.ConvertRadix10(item.Opts.Alias)
+		} else {
+			keys[p], keyIds[p] = generator.ConvertRadix62(id), id
+		}
+	}
+
+	// The statement only sees the rows from before its insert, d is the link already shared under the dedup hash
+	rows, err := tx.QueryContext(ctx, `WITH input AS (
+			SELECT * FROM unnest($1::BIGINT[], $2::TEXT[], $3::BIGINT[], $4::TEXT[], $5::TEXT[], $6::TEXT[], $7::TEXT[], $8::TEXT[])
+				WITH ORDINALITY AS t(id, key, key_id, alias, url, expires_at, dedup_hash, owner, n)
+		), inserted AS (
+			INSERT INTO shorturl (id, alias, url, expires_at, dedup_hash, owner)
+			SELECT id, alias, url, expires_at::TIMESTAMPTZ, decode(dedup_hash, 'hex'), owner FROM input i
+			WHERE NOT EXISTS (SELECT 1 FROM shorturl s WHERE s.alias = i.key OR (i.alias IS NOT NULL AND s.alias IS NULL AND s.id = i.key_id))
+			ON CONFLICT DO NOTHING RETURNING id
+		)
+		SELECT i.n, ins.id IS NOT NULL, d.id FROM input i
+			LEFT JOIN inserted ins ON ins.id = i.id
+			LEFT JOIN shorturl d ON d.dedup_hash = decode(i.dedup_hash, 'hex')
+		ORDER BY i.n`,
+		pq.Array(ids), pq.Array(keys), pq.Array(keyIds), pq.Array(aliases), pq.Array(urls), pq.Array(expiresAt), pq.Array(hashes), pq.Array(owners))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var retry []int
+	for rows.Next() {
+		var p int
+		var inserted bool
+		var dedupId sql.NullInt64
+		if err := rows.Scan(&p, &inserted, &dedupId); err != nil {
+			return nil, err
+		}
+		i := pending[p-1]
+		alias := items[i].Opts.Alias
+		switch {
+		case inserted && alias != "":
+			results[i] = BatchResult{Key: alias}
+		case inserted:
+			results[i] = BatchResult{Key: generator.ConvertRadix62(ids[p-1])}
+		case alias != "":
+			results[i] = BatchResult{Err: ErrKeyAlreadyExists}
+		case dedupId.Valid:
+			results[i] = BatchResult{Key: generator.ConvertRadix62(dedupId.Int64)}
+		default:
+			s.Log.Printf("Generated key(%s) already exists, retrying", keys[p-1])
+			retry = append(retry, i)
+		}
+	}
+	return retry, rows.Err()
+}
+
+// dedupKey returns the key of the link shared under the dedup hash, empty when there is none
+func (s PostgresStore) dedupKey(ctx context.Context, hash []byte) (string, error) {
 	var k int64
-	err := s.db.QueryRow("SELECT id FROM shorturl WHERE url = $1 LIMIT 1", originalURL).Scan(&k)
-	if err != nil && err != sql.ErrNoRows {
+	err := s.db.QueryRowContext(ctx, "SELECT id FROM shorturl WHERE dedup_hash = $1", hash).Scan(&k)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
 		s.Log.Println("Error checking if key exists: ", err)
-		return "", ErrKeyAlreadyExists
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		return "", err
 	}
+	return generator.ConvertRadix62(k), nil
+}
 
-	if k != 0 {
-		return generator.ConvertRadix62(k), nil
-	}
+// setAlias stores the original URL under a custom alias.
+// Aliased rows still get a snowflake id so they can be paged with the generated ones.
+func (s PostgresStore) setAlias(ctx context.Context, originalURL string, opts SetOptions) (string, error) {
+	alias := opts.Alias
+	// Aliases too long to be a radix62 id can only collide with other aliases
+	k, _ := generator.ConvertRadix10(alias)
 
-	newId, err := generator.GenerateSnowFlakeKey()
+	newId, err := s.aliasIds.NextID(originalURL)
 	if err != nil {
 		s.Log.Println("Error generating snowflake key: ", err)
 		return "", err
 	}
 
-	err = s.db.QueryRow("INSERT INTO shorturl (id, url) VALUES ($1,$2) RETURNING id", newId, originalURL).Scan(&k)
-	if err != nil {
-		s.Log.Println("Error inserting into database: ", err)
+	// Reject aliases already taken by another alias or by a generated key
+	var id int64
+	err = s.db.QueryRowContext(ctx, `INSERT INTO shorturl (id, alias, url, expires_at, owner) SELECT $3, $1, $4, $5, $6
+		WHERE NOT EXISTS (SELECT 1 FROM shorturl WHERE `+keyCondition+`)
+		ON CONFLICT (alias) DO NOTHING RETURNING id`,
+		alias, k, newId, originalURL, nullTime(opts.ExpiresAt), opts.Owner).Scan(&id)
+	if err != nil && err == sql.ErrNoRows {
 		return "", ErrKeyAlreadyExists
 	}
 
-	s.Log.Println("Inserted into database: ", k)
-	return generator.ConvertRadix62(k), nil
+	if err != nil {
+		s.Log.Println("Error inserting alias into database: ", err)
+		return "", err
+	}
+
+	s.Log.Println("Inserted alias into database: ", alias)
+	return alias, nil
+}
+
+func (s PostgresStore) Update(ctx context.Context, shortKey string, originalURL string) error {
+	// Keys that are not radix62 ids can still be aliases
+	k, _ := generator.ConvertRadix10(shortKey)
+
+	// The link no longer points at the url it was shared for
+	res, err := s.db.ExecContext(ctx, "UPDATE shorturl SET url = $3, dedup_hash = NULL WHERE "+keyCondition, shortKey, k, originalURL)
+	if err != nil {
+		s.Log.Println("Error updating database: ", err, k)
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrKeyNotFound
+	}
+
+	s.Log.Println("Updated in database: ", shortKey)
+	return nil
+}
+
+func (s PostgresStore) Delete(ctx context.Context, shortKey string) error {
+	// Keys that are not radix62 ids can still be aliases
+	k, _ := generator.ConvertRadix10(shortKey)
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM shorturl WHERE "+keyCondition, shortKey, k)
+	if err != nil {
+		s.Log.Println("Error deleting from database: ", err, k)
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrKeyNotFound
+	}
+
+	s.Log.Println("Deleted from database: ", shortKey)
+	return nil
+}
+
+// List pages through the links ordered by id, the cursor is the radix62 id of the last link of the previous page
+func (s PostgresStore) List(ctx context.Context, cursor string, limit int) ([]Link, string, error) {
+	return s.list(ctx, cursor, limit, "")
+}
+
+func (s PostgresStore) ListByOwner(ctx context.Context, owner string, cursor string, limit int) ([]Link, string, error) {
+	return s.list(ctx, cursor, limit, "owner = $3 AND", owner)
+}
+
+// list pages through the links matching the condition, whose arguments start at $3
+func (s PostgresStore) list(ctx context.Context, cursor string, limit int, condition string, args ...any) ([]Link, string, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	var after int64
+	if cursor != "" {
+		k, err := generator.ConvertRadix10(cursor)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		after = k
+	}
+
+	// Fetch one extra row to know whether there is a next page
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, alias, url, created_at, expires_at, owner FROM shorturl WHERE "+condition+" id > $1 ORDER BY id LIMIT $2",
+		append([]any{after, limit + 1}, args...)...)
+	if err != nil {
+		s.Log.Println("Error listing database: ", err)
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	links := make([]Link, 0, limit)
+	ids := make([]int64, 0, limit)
+	for rows.Next() {
+		var id int64
+		var alias sql.NullString
+		var expiresAt sql.NullTime
+		var link Link
+		if err := rows.Scan(&id, &alias, &link.URL, &link.CreatedAt, &expiresAt, &link.Owner); err != nil {
+			return nil, "", err
+		}
+		link.ExpiresAt = expiresAt.Time
+		link.Key = generator.ConvertRadix62(id)
+		if alias.Valid {
+			link.Key = alias.String
+		}
+		links = append(links, link)
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(links) > limit {
+		links = links[:limit]
+		next = generator.ConvertRadix62(ids[limit-1])
+	}
+	return links, next, nil
+}
+
+func (s PostgresStore) PurgeExpired(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM shorturl WHERE expires_at < $1", before)
+	if err != nil {
+		s.Log.Println("Error purging expired links: ", err)
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func (s PostgresStore) CreateAPIKey(ctx context.Context, key APIKey) error {
+	res, err := s.db.ExecContext(ctx, `INSERT INTO api_keys (id, key_hash, owner, user_id, scopes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING`,
+		key.ID, key.Hash, key.Owner, key.UserID, joinScopes(key.Scopes), key.CreatedAt)
+	if err != nil {
+		s.Log.Println("Error inserting api key into database: ", err)
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrAPIKeyAlreadyExists
+	}
+
+	s.Log.Println("Inserted api key into database: ", key.ID)
+	return nil
+}
+
+func (s PostgresStore) GetAPIKey(ctx context.Context, hash []byte) (APIKey, error) {
+	row := s.db.QueryRowContext(ctx,
+		"SELECT id, key_hash, owner, user_id, scopes, created_at, revoked_at FROM api_keys WHERE key_hash = $1", hash)
+	key, err := scanAPIKey(row.Scan)
+	if err == sql.ErrNoRows {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		s.Log.Println("Error querying api key: ", err)
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+func (s PostgresStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, key_hash, owner, user_id, scopes, created_at, revoked_at FROM api_keys ORDER BY created_at, id")
+	if err != nil {
+		s.Log.Println("Error listing api keys: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s PostgresStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1", id, at)
+	if err != nil {
+		s.Log.Println("Error revoking api key: ", err)
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	s.Log.Println("Revoked api key: ", id)
+	return nil
+}
+
+// scanAPIKey reads the id, key_hash, owner, user_id, scopes, created_at and revoked_at columns
+func scanAPIKey(scan func(dest ...any) error) (APIKey, error) {
+	var key APIKey
+	var scopes string
+	var revokedAt sql.NullTime
+	if err := scan(&key.ID, &key.Hash, &key.Owner, &key.UserID, &scopes, &key.CreatedAt, &revokedAt); err != nil {
+		return APIKey{}, err
+	}
+	key.Scopes = splitScopes(scopes)
+	key.RevokedAt = revokedAt.Time
+	return key, nil
+}
+
+func (s PostgresStore) CreateUser(ctx context.Context, user User) error {
+	res, err := s.db.ExecContext(ctx, "INSERT INTO users (id, name, created_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+		user.ID, user.Name, user.CreatedAt)
+	if err != nil {
+		s.Log.Println("Error inserting user into database: ", err)
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+func (s PostgresStore) ListUsers(ctx context.Context) ([]User, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, created_at FROM users ORDER BY created_at, id")
+	if err != nil {
+		s.Log.Println("Error listing users: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Name, &user.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s PostgresStore) CreateWorkspace(ctx context.Context, workspace Workspace, first Member) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO workspaces (id, name, created_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+		workspace.ID, workspace.Name, workspace.CreatedAt)
+	if err != nil {
+		s.Log.Println("Error inserting workspace into database: ", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrAlreadyExists
+	}
+
+	res, err = tx.ExecContext(ctx, `INSERT INTO workspace_members (workspace_id, user_id, role)
+		SELECT $1, $2, $3 WHERE EXISTS (SELECT 1 FROM users WHERE id = $2)`,
+		workspace.ID, first.UserID, first.Role)
+	if err != nil {
+		s.Log.Println("Error inserting member into database: ", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (s PostgresStore) ListWorkspaces(ctx context.Context) ([]Workspace, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, created_at FROM workspaces ORDER BY created_at, id")
+	if err != nil {
+		s.Log.Println("Error listing workspaces: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := []Workspace{}
+	for rows.Next() {
+		var workspace Workspace
+		if err := rows.Scan(&workspace.ID, &workspace.Name, &workspace.CreatedAt); err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	return workspaces, rows.Err()
+}
+
+func (s PostgresStore) SetMember(ctx context.Context, member Member) error {
+	tx, err := s.lockWorkspace(ctx, member.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if member.Role != RoleOwner {
+		if err := s.checkNotLastOwner(ctx, tx, member.WorkspaceID, member.UserID); err != nil {
+			return err
+		}
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO workspace_members (workspace_id, user_id, role)
+		SELECT $1, $2, $3 WHERE EXISTS (SELECT 1 FROM users WHERE id = $2)
+		ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = EXCLUDED.role`,
+		member.WorkspaceID, member.UserID, member.Role)
+	if err != nil {
+		s.Log.Println("Error setting member: ", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+	return tx.Commit()
+}
+
+// lockWorkspace begins a transaction holding the row of the workspace until it ends, which serializes the changes
+// of its members: two owners stepping down at the same time would otherwise both see the other one still owner.
+// It returns ErrWorkspaceNotFound when the workspace does not exist.
+func (s PostgresStore) lockWorkspace(ctx context.Context, workspaceID string) (*sql.Tx, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		s.Log.Println("Error beginning transaction: ", err)
+		return nil, err
+	}
+
+	var id string
+	err = tx.QueryRowContext(ctx, "SELECT id FROM workspaces WHERE id = $1 FOR UPDATE", workspaceID).Scan(&id)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return nil, ErrWorkspaceNotFound
+	}
+	if err != nil {
+		s.Log.Println("Error locking workspace: ", err)
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// checkNotLastOwner returns ErrLastOwner when the user is the only owner of the workspace locked by the transaction
+func (s PostgresStore) checkNotLastOwner(ctx context.Context, tx *sql.Tx, workspaceID string, userID string) error {
+	var last bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM workspace_members WHERE workspace_id = $1 AND user_id = $2 AND role = $3)
+		AND NOT EXISTS (SELECT 1 FROM workspace_members WHERE workspace_id = $1 AND user_id <> $2 AND role = $3)`,
+		workspaceID, userID, RoleOwner).Scan(&last)
+	if err != nil {
+		s.Log.Println("Error querying owners: ", err)
+		return err
+	}
+	if last {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+func (s PostgresStore) GetMember(ctx context.Context, workspaceID string, userID string) (Member, error) {
+	member := Member{WorkspaceID: workspaceID, UserID: userID}
+	err := s.db.QueryRowContext(ctx, "SELECT role FROM workspace_members WHERE workspace_id = $1 AND user_id = $2",
+		workspaceID, userID).Scan(&member.Role)
+	if err == sql.ErrNoRows {
+		return Member{}, ErrMemberNotFound
+	}
+	if err != nil {
+		s.Log.Println("Error querying member: ", err)
+		return Member{}, err
+	}
+	return member, nil
+}
+
+func (s PostgresStore) ListMembers(ctx context.Context, workspaceID string) ([]Member, error) {
+	var found bool
+	if err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM workspaces WHERE id = $1)", workspaceID).Scan(&found); err != nil {
+		s.Log.Println("Error listing members: ", err)
+		return nil, err
+	}
+	if !found {
+		return nil, ErrWorkspaceNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT user_id, role FROM workspace_members WHERE workspace_id = $1 ORDER BY user_id", workspaceID)
+	if err != nil {
+		s.Log.Println("Error listing members: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []Member{}
+	for rows.Next() {
+		member := Member{WorkspaceID: workspaceID}
+		if err := rows.Scan(&member.UserID, &member.Role); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (s PostgresStore) RemoveMember(ctx context.Context, workspaceID string, userID string) error {
+	tx, err := s.lockWorkspace(ctx, workspaceID)
+	if err == ErrWorkspaceNotFound {
+		return ErrMemberNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.checkNotLastOwner(ctx, tx, workspaceID, userID); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2", workspaceID, userID)
+	if err != nil {
+		s.Log.Println("Error removing member: ", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrMemberNotFound
+	}
+	return tx.Commit()
+}
+
+// nullTime maps the zero time to NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
 }
@@ -0,0 +1,940 @@
+package store
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/redis/go-redis/v9"
+	generator "go-url-short/internal/shorten"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// RedisModeCache puts redis in front of the configured store as a read-through cache
+	RedisModeCache = "cache"
+	// RedisModeStore keeps the links in redis only
+	RedisModeStore = "store"
+)
+
+type RedisConfig struct {
+	Addr     string        `default:"" desc:"Address (host:port) of a redis compatible server, empty disables redis"`
+	Password string        `default:"" desc:"Redis password"`
+	DB       int           `default:"0" desc:"Redis database number"`
+	Mode     string        `default:"cache" desc:"cache puts redis in front of the store, store keeps the links in redis only"`
+	TTL      time.Duration `default:"1h" desc:"How long a resolved link stays cached"`
+	Timeout  time.Duration `default:"100ms" desc:"Redis dial, read and write timeout"`
+	Backoff  time.Duration `default:"10s" desc:"How long the cache is bypassed after a redis error"`
+}
+
+// NewRedisClient connects to the configured server, it is shared with the other features keeping state in redis
+func NewRedisClient(config *RedisConfig) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:         config.Addr,
+		Password:     config.Password,
+		DB:           config.DB,
+		DialTimeout:  config.Timeout,
+		ReadTimeout:  config.Timeout,
+		WriteTimeout: config.Timeout,
+	})
+}
+
+// The keys touched by the scripts share the {shorturl} hash tag so that they hash to the same Redis Cluster slot
+const (
+	redisLinkPrefix = "{shorturl}:link:"
+	// redisKeys is a sorted set of every short key with the same score, ordered lexicographically for List
+	redisKeys = "{shorturl}:keys"
+	// redisExpiry is a sorted set of the expiring short keys scored by their expiry in unix milliseconds
+	redisExpiry      = "{shorturl}:expiry"
+	redisCachePrefix = "shorturl:cache:"
+	redisDedupPrefix = "{shorturl}:dedup:"
+	// redisLastID holds the highest generated ID, the sequential generator is seeded from it
+	redisLastID = "{shorturl}:lastid"
+
+	redisAPIKeyPrefix = "{shorturl}:apikey:"
+	// redisAPIKeyHashPrefix names the strings holding the ID of the API key with the hex encoded hash
+	redisAPIKeyHashPrefix = "{shorturl}:apikeyhash:"
+	// redisAPIKeys is a sorted set of the API key IDs scored by their creation in unix milliseconds
+	redisAPIKeys = "{shorturl}:apikeys"
+
+	// redisOwnerPrefix names the sorted sets of the short keys of each owner, ordered like redisKeys
+	redisOwnerPrefix = "{shorturl}:owner:"
+	redisUserPrefix  = "{shorturl}:user:"
+	// redisUsers and redisWorkspaces are sorted sets of the IDs scored by their creation in unix milliseconds
+	redisUsers           = "{shorturl}:users"
+	redisWorkspacePrefix = "{shorturl}:workspace:"
+	redisWorkspaces      = "{shorturl}:workspaces"
+	// redisMembersPrefix names the hashes of the roles of the members of each workspace by user ID
+	redisMembersPrefix = "{shorturl}:members:"
+)
+
+// Links are hashes of url, created_at, expires_at, owner and dedup, the scripts keep them and both indexes consistent.
+// KEYS are the link hash, redisKeys and redisExpiry, the first ARGV is always the short key.
+// dedup names the string holding the key shared for the url, it is dropped along with the link or its url.
+var (
+	// redisSetScript returns 0 when the key is taken, 1 when the link is created or the key shared for the url.
+	// KEYS[4] is the dedup string, only used when ARGV[5] is 1, KEYS[5] the sorted set of the keys of the owner
+	// and KEYS[6] redisLastID, raised to the generated ID ARGV[7] unless it is 0.
+	redisSetScript = redis.NewScript(`
+if ARGV[5] == '1' then
+	local shared = redis.call('GET', KEYS[4])
+	if shared then
+		return shared
+	end
+end
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'url', ARGV[2], 'created_at', ARGV[3], 'expires_at', ARGV[4], 'owner', ARGV[6])
+redis.call('ZADD', KEYS[2], 0, ARGV[1])
+redis.call('ZADD', KEYS[5], 0, ARGV[1])
+if ARGV[4] ~= '0' then
+	redis.call('ZADD', KEYS[3], ARGV[4], ARGV[1])
+end
+if ARGV[5] == '1' then
+	redis.call('SET', KEYS[4], ARGV[1])
+	redis.call('HSET', KEYS[1], 'dedup', KEYS[4])
+end
+if ARGV[7] ~= '0' then
+	local last = redis.call('GET', KEYS[6])
+	if not last or tonumber(ARGV[7]) > tonumber(last) then
+		redis.call('SET', KEYS[6], ARGV[7])
+	end
+end
+return 1`)
+
+	// redisUpdateScript takes the dedup string KEYS[4] read beforehand along with its name ARGV[3], empty when there
+	// is none. It returns 0 when there is no such link and -1 when the dedup string changed in between.
+	redisUpdateScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+local dedup = redis.call('HGET', KEYS[1], 'dedup') or ''
+if dedup ~= ARGV[3] then
+	return -1
+end
+if dedup ~= '' then
+	redis.call('DEL', KEYS[4])
+	redis.call('HDEL', KEYS[1], 'dedup')
+end
+redis.call('HSET', KEYS[1], 'url', ARGV[2])
+return 1`)
+
+	// redisDeleteScript takes the dedup string KEYS[4] and the sorted set of the owner KEYS[5] read beforehand,
+	// along with the dedup name ARGV[2] and the owner ARGV[3]. It returns -1 when they changed in between.
+	redisDeleteScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+local dedup = redis.call('HGET', KEYS[1], 'dedup') or ''
+local owner = redis.call('HGET', KEYS[1], 'owner') or ''
+if dedup ~= ARGV[2] or owner ~= ARGV[3] then
+	return -1
+end
+if dedup ~= '' then
+	redis.call('DEL', KEYS[4])
+end
+redis.call('ZREM', KEYS[5], ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+return redis.call('DEL', KEYS[1])`)
+)
+
+// API keys are hashes of hash, owner, user_id, scopes, created_at and revoked_at.
+// KEYS are the key hash, the string of its hash and redisAPIKeys, the first ARGV is always the ID.
+var (
+	// redisCreateAPIKeyScript returns 0 when the ID or the hash is already taken
+	redisCreateAPIKeyScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 or redis.call('EXISTS', KEYS[2]) == 1 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'hash', ARGV[2], 'owner', ARGV[3], 'scopes', ARGV[4], 'created_at', ARGV[5], 'user_id', ARGV[6])
+redis.call('SET', KEYS[2], ARGV[1])
+redis.call('ZADD', KEYS[3], ARGV[5], ARGV[1])
+return 1`)
+
+	// redisRevokeAPIKeyScript returns 0 when there is no such key, only KEYS[1] is passed
+	redisRevokeAPIKeyScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSETNX', KEYS[1], 'revoked_at', ARGV[2])
+return 1`)
+)
+
+// Users and workspaces are hashes of name and created_at, the members of a workspace a hash of roles by user ID.
+var (
+	// redisCreateScript creates the user or workspace hash KEYS[1] and indexes it in KEYS[2], it returns 0 when the ID is taken
+	redisCreateScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'name', ARGV[2], 'created_at', ARGV[3])
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+return 1`)
+
+	// redisCreateWorkspaceScript also takes the hash of the first member KEYS[3] and the members hash KEYS[4].
+	// It returns 0 when the ID is taken and -1 when the user does not exist.
+	redisCreateWorkspaceScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+if redis.call('EXISTS', KEYS[3]) == 0 then
+	return -1
+end
+redis.call('HSET', KEYS[1], 'name', ARGV[2], 'created_at', ARGV[3])
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+redis.call('HSET', KEYS[4], ARGV[4], ARGV[5])
+return 1`)
+
+	// redisSetMemberScript takes the workspace, user and members hashes and RoleOwner as ARGV[3].
+	// It returns 0 when the workspace does not exist, -1 when the user does not exist and -2 when it is the last owner.
+	redisSetMemberScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	return -1
+end
+if ARGV[2] ~= ARGV[3] and redis.call('HGET', KEYS[3], ARGV[1]) == ARGV[3] then
+	local owners = 0
+	for _, role in ipairs(redis.call('HVALS', KEYS[3])) do
+		if role == ARGV[3] then
+			owners = owners + 1
+		end
+	end
+	if owners == 1 then
+		return -2
+	end
+end
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[2])
+return 1`)
+
+	// redisRemoveMemberScript takes the members hash and RoleOwner as ARGV[2].
+	// It returns 0 when the user is not a member and -2 when it is the last owner.
+	redisRemoveMemberScript = redis.NewScript(`
+local role = redis.call('HGET', KEYS[1], ARGV[1])
+if not role then
+	return 0
+end
+if role == ARGV[2] then
+	local owners = 0
+	for _, role in ipairs(redis.call('HVALS', KEYS[1])) do
+		if role == ARGV[2] then
+			owners = owners + 1
+		end
+	end
+	if owners == 1 then
+		return -2
+	end
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+return 1`)
+)
+
+// RedisStore keeps the links in a redis compatible server
+type RedisStore struct {
+	client *redis.Client
+	gen    generator.KeyGenerator
+	Log    *log.Logger
+}
+
+func NewRedisStore(config *RedisConfig, gen generator.KeyGenerator) (*RedisStore, error) {
+	l := log.New(log.Writer(), "REDISSTORE:", log.LstdFlags)
+	l.Print("Connecting to redis ", config.Addr)
+	client := NewRedisClient(config)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	if seeder, ok := gen.(generator.Seeder); ok {
+		last, err := client.Get(context.Background(), redisLastID).Int64()
+		if err != nil && err != redis.Nil {
+			client.Close()
+			return nil, err
+		}
+		seeder.Seed(last)
+	}
+
+	return &RedisStore{
+		client: client,
+		gen:    gen,
+		Log:    l,
+	}, nil
+}
+
+func (s *RedisStore) DbClose(ctx context.Context) {
+	s.Log.Println("Closing database connection")
+	s.client.Close()
+}
+
+func redisLinkKeys(shortKey string) []string {
+	return []string{redisLinkPrefix + shortKey, redisKeys, redisExpiry}
+}
+
+func (s *RedisStore) Get(ctx context.Context, shortKey string) (string, error) {
+	link, err := s.GetLink(ctx, shortKey)
+	if err != nil {
+		return "", err
+	}
+
+	if link.Expired(time.Now()) {
+		return "", ErrKeyExpired
+	}
+
+	return link.URL, nil
+}
+
+func (s *RedisStore) GetLink(ctx context.Context, shortKey string) (Link, error) {
+	values, err := s.client.HMGet(ctx, redisLinkPrefix+shortKey, "url", "created_at", "expires_at", "owner").Result()
+	if err != nil {
+		s.Log.Println("Error querying redis: ", err)
+		return Link{}, err
+	}
+	return parseRedisLink(shortKey, values)
+}
+
+// parseRedisLink reads the url, created_at, expires_at and owner fields of a link hash
+func parseRedisLink(shortKey string, values []interface{}) (Link, error) {
+	url, ok := values[0].(string)
+	if !ok {
+		return Link{}, ErrKeyNotFound
+	}
+
+	link := Link{Key: shortKey, URL: url}
+	if createdAt, ok := values[1].(string); ok {
+		ms, _ := strconv.ParseInt(createdAt, 10, 64)
+		link.CreatedAt = time.UnixMilli(ms)
+	}
+	if expiresAt, ok := values[2].(string); ok && expiresAt != "0" {
+		ms, _ := strconv.ParseInt(expiresAt, 10, 64)
+		link.ExpiresAt = time.UnixMilli(ms)
+	}
+	link.Owner, _ = values[3].(string)
+	return link, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, originalURL string, opts SetOptions) (string, error) {
+	if opts.Alias != "" {
+		key, err := s.put(ctx, opts.Alias, 0, originalURL, opts)
+		if err != nil {
+			return "", err
+		}
+		if key == "" {
+			return "", ErrKeyAlreadyExists
+		}
+		return key, nil
+	}
+
+	if grower, ok := s.gen.(generator.Grower); ok {
+		used, err := s.client.ZCard(ctx, redisKeys).Result()
+		if err != nil {
+			s.Log.Println("Error counting keys: ", err)
+			return "", err
+		}
+		grower.Grow(int(used))
+	}
+
+	for attempt := 1; attempt <= maxSetAttempts; attempt++ {
+		id, err := nextID(s.gen, originalURL, attempt)
+		if err != nil {
+			s.Log.Println("Error generating key: ", err)
+			return "", err
+		}
+
+		shortKey := generator.ConvertRadix62(id)
+		key, err := s.put(ctx, shortKey, id, originalURL, opts)
+		if err != nil {
+			return "", err
+		}
+		if key == "" {
+			s.Log.Printf("Generated key(%s) already exists, attempt %d", shortKey, attempt)
+			continue
+		}
+		return key, nil
+	}
+
+	return "", ErrKeyGenerationFailed
+}
+
+func (s *RedisStore) SetBatch(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	return setEach(ctx, s.Set, items)
+}
+
+// put stores a new link and returns its key, or the key already shared for the url.
+// It returns an empty key when the short key is already taken, id is the generated ID of the key or 0 for an alias.
+func (s *RedisStore) put(ctx context.Context, shortKey string, id int64, originalURL string, opts SetOptions) (string, error) {
+	var expiresAt int64
+	if !opts.ExpiresAt.IsZero() {
+		expiresAt = opts.ExpiresAt.UnixMilli()
+	}
+	keys, dedup := append(redisLinkKeys(shortKey), redisDedupPrefix, redisOwnerPrefix+opts.Owner, redisLastID), 0
+	if hash := opts.dedupHash(originalURL); hash != nil {
+		keys[3], dedup = redisDedupPrefix+hex.EncodeToString(hash), 1
+	}
+
+	res, err := redisSetScript.Run(ctx, s.client, keys,
+		shortKey, originalURL, time.Now().UnixMilli(), expiresAt, dedup, opts.Owner, id).Result()
+	if err != nil {
+		s.Log.Println("Error inserting into redis: ", err)
+		return "", err
+	}
+
+	switch res := res.(type) {
+	case string:
+		return res, nil
+	case int64:
+		if res == 1 {
+			return shortKey, nil
+		}
+	}
+	return "", nil
+}
+
+func (s *RedisStore) Update(ctx context.Context, shortKey string, originalURL string) error {
+	for {
+		dedup, _, err := s.linkRefs(ctx, shortKey)
+		if err != nil {
+			s.Log.Println("Error updating redis: ", err)
+			return err
+		}
+
+		keys := append(redisLinkKeys(shortKey), redisDedupKey(dedup))
+		updated, err := redisUpdateScript.Run(ctx, s.client, keys, shortKey, originalURL, dedup).Int()
+		if err != nil {
+			s.Log.Println("Error updating redis: ", err)
+			return err
+		}
+
+		switch updated {
+		case -1:
+			continue
+		case 0:
+			return ErrKeyNotFound
+		}
+		return nil
+	}
+}
+
+func (s *RedisStore) Delete(ctx context.Context, shortKey string) error {
+	deleted, err := s.delete(ctx, shortKey)
+	if err != nil {
+		s.Log.Println("Error deleting from redis: ", err)
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// delete drops the link and its references, it returns 0 when there is no such link
+func (s *RedisStore) delete(ctx context.Context, shortKey string) (int64, error) {
+	for {
+		dedup, owner, err := s.linkRefs(ctx, shortKey)
+		if err != nil {
+			return 0, err
+		}
+
+		keys := append(redisLinkKeys(shortKey), redisDedupKey(dedup), redisOwnerPrefix+owner)
+		deleted, err := redisDeleteScript.Run(ctx, s.client, keys, shortKey, dedup, owner).Int64()
+		if err != nil {
+			return 0, err
+		}
+		if deleted != -1 {
+			return deleted, nil
+		}
+	}
+}
+
+// linkRefs reads the name of the dedup string and the owner of the link, the scripts are given the keys they name
+// and check they did not change before using them
+func (s *RedisStore) linkRefs(ctx context.Context, shortKey string) (string, string, error) {
+	values, err := s.client.HMGet(ctx, redisLinkPrefix+shortKey, "dedup", "owner").Result()
+	if err != nil {
+		return "", "", err
+	}
+	dedup, _ := values[0].(string)
+	owner, _ := values[1].(string)
+	return dedup, owner, nil
+}
+
+// redisDedupKey is the dedup string to pass to the scripts, redisDedupPrefix stands in when the link has none
+func redisDedupKey(dedup string) string {
+	if dedup == "" {
+		return redisDedupPrefix
+	}
+	return dedup
+}
+
+// List pages through the links ordered by key, the cursor is the last key of the previous page
+func (s *RedisStore) List(ctx context.Context, cursor string, limit int) ([]Link, string, error) {
+	return s.list(ctx, redisKeys, cursor, limit)
+}
+
+func (s *RedisStore) ListByOwner(ctx context.Context, owner string, cursor string, limit int) ([]Link, string, error) {
+	return s.list(ctx, redisOwnerPrefix+owner, cursor, limit)
+}
+
+// list pages through the links of the sorted set of short keys
+func (s *RedisStore) list(ctx context.Context, index string, cursor string, limit int) ([]Link, string, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	start := "-"
+	if cursor != "" {
+		start = "(" + cursor
+	}
+	// Fetch one extra key to know whether there is a next page
+	keys, err := s.client.ZRangeByLex(ctx, index, &redis.ZRangeBy{Min: start, Max: "+", Count: int64(limit + 1)}).Result()
+	if err != nil {
+		s.Log.Println("Error listing redis: ", err)
+		return nil, "", err
+	}
+
+	next := ""
+	if len(keys) > limit {
+		keys = keys[:limit]
+		next = keys[limit-1]
+	}
+
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.SliceCmd, len(keys))
+	for i, k := range keys {
+		cmds[i] = pipe.HMGet(ctx, redisLinkPrefix+k, "url", "created_at", "expires_at", "owner")
+	}
+	if len(keys) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			s.Log.Println("Error listing redis: ", err)
+			return nil, "", err
+		}
+	}
+
+	links := make([]Link, 0, len(keys))
+	for i, k := range keys {
+		link, err := parseRedisLink(k, cmds[i].Val())
+		if err != nil {
+			// Deleted in between both reads
+			continue
+		}
+		links = append(links, link)
+	}
+	return links, next, nil
+}
+
+func (s *RedisStore) PurgeExpired(ctx context.Context, before time.Time) (int64, error) {
+	keys, err := s.client.ZRangeByScore(ctx, redisExpiry, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(before.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		s.Log.Println("Error purging expired links: ", err)
+		return 0, err
+	}
+
+	var n int64
+	for _, k := range keys {
+		deleted, err := s.delete(ctx, k)
+		if err != nil {
+			s.Log.Println("Error purging expired links: ", err)
+			return n, err
+		}
+		n += deleted
+	}
+	return n, nil
+}
+
+func (s *RedisStore) CreateAPIKey(ctx context.Context, key APIKey) error {
+	hash := hex.EncodeToString(key.Hash)
+	created, err := redisCreateAPIKeyScript.Run(ctx, s.client,
+		[]string{redisAPIKeyPrefix + key.ID, redisAPIKeyHashPrefix + hash, redisAPIKeys},
+		key.ID, hash, key.Owner, joinScopes(key.Scopes), key.CreatedAt.UnixMilli(), key.UserID).Int()
+	if err != nil {
+		s.Log.Println("Error inserting api key into redis: ", err)
+		return err
+	}
+
+	if created == 0 {
+		return ErrAPIKeyAlreadyExists
+	}
+	return nil
+}
+
+func (s *RedisStore) GetAPIKey(ctx context.Context, hash []byte) (APIKey, error) {
+	id, err := s.client.Get(ctx, redisAPIKeyHashPrefix+hex.EncodeToString(hash)).Result()
+	if err == redis.Nil {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		s.Log.Println("Error querying api key: ", err)
+		return APIKey{}, err
+	}
+
+	values, err := s.client.HMGet(ctx, redisAPIKeyPrefix+id, redisAPIKeyFields...).Result()
+	if err != nil {
+		s.Log.Println("Error querying api key: ", err)
+		return APIKey{}, err
+	}
+	return parseRedisAPIKey(id, values)
+}
+
+func (s *RedisStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	ids, err := s.client.ZRange(ctx, redisAPIKeys, 0, -1).Result()
+	if err != nil {
+		s.Log.Println("Error listing api keys: ", err)
+		return nil, err
+	}
+
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.SliceCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HMGet(ctx, redisAPIKeyPrefix+id, redisAPIKeyFields...)
+	}
+	if len(ids) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			s.Log.Println("Error listing api keys: ", err)
+			return nil, err
+		}
+	}
+
+	keys := make([]APIKey, 0, len(ids))
+	for i, id := range ids {
+		key, err := parseRedisAPIKey(id, cmds[i].Val())
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	// Keys created in the same millisecond are ordered by ID like the other stores
+	sortAPIKeys(keys)
+	return keys, nil
+}
+
+func (s *RedisStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	revoked, err := redisRevokeAPIKeyScript.Run(ctx, s.client, []string{redisAPIKeyPrefix + id}, id, at.UnixMilli()).Int()
+	if err != nil {
+		s.Log.Println("Error revoking api key: ", err)
+		return err
+	}
+
+	if revoked == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+var redisAPIKeyFields = []string{"hash", "owner", "scopes", "created_at", "revoked_at", "user_id"}
+
+// parseRedisAPIKey reads the redisAPIKeyFields of an API key hash
+func parseRedisAPIKey(id string, values []interface{}) (APIKey, error) {
+	hash, ok := values[0].(string)
+	if !ok {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+
+	key := APIKey{ID: id}
+	key.Hash, _ = hex.DecodeString(hash)
+	key.Owner, _ = values[1].(string)
+	scopes, _ := values[2].(string)
+	key.Scopes = splitScopes(scopes)
+	if createdAt, ok := values[3].(string); ok {
+		ms, _ := strconv.ParseInt(createdAt, 10, 64)
+		key.CreatedAt = time.UnixMilli(ms)
+	}
+	if revokedAt, ok := values[4].(string); ok {
+		ms, _ := strconv.ParseInt(revokedAt, 10, 64)
+		key.RevokedAt = time.UnixMilli(ms)
+	}
+	key.UserID, _ = values[5].(string)
+	return key, nil
+}
+
+func (s *RedisStore) CreateUser(ctx context.Context, user User) error {
+	created, err := redisCreateScript.Run(ctx, s.client, []string{redisUserPrefix + user.ID, redisUsers},
+		user.ID, user.Name, user.CreatedAt.UnixMilli()).Int()
+	if err != nil {
+		s.Log.Println("Error inserting user into redis: ", err)
+		return err
+	}
+
+	if created == 0 {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+func (s *RedisStore) ListUsers(ctx context.Context) ([]User, error) {
+	ids, values, err := s.listCreated(ctx, redisUsers, redisUserPrefix)
+	if err != nil {
+		s.Log.Println("Error listing users: ", err)
+		return nil, err
+	}
+
+	users := make([]User, 0, len(ids))
+	for i, id := range ids {
+		if name, createdAt, ok := parseRedisCreated(values[i]); ok {
+			users = append(users, User{ID: id, Name: name, CreatedAt: createdAt})
+		}
+	}
+	sortUsers(users)
+	return users, nil
+}
+
+func (s *RedisStore) CreateWorkspace(ctx context.Context, workspace Workspace, first Member) error {
+	created, err := redisCreateWorkspaceScript.Run(ctx, s.client,
+		[]string{redisWorkspacePrefix + workspace.ID, redisWorkspaces, redisUserPrefix + first.UserID, redisMembersPrefix + workspace.ID},
+		workspace.ID, workspace.Name, workspace.CreatedAt.UnixMilli(), first.UserID, first.Role).Int()
+	if err != nil {
+		s.Log.Println("Error inserting workspace into redis: ", err)
+		return err
+	}
+
+	switch created {
+	case 0:
+		return ErrAlreadyExists
+	case -1:
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *RedisStore) ListWorkspaces(ctx context.Context) ([]Workspace, error) {
+	ids, values, err := s.listCreated(ctx, redisWorkspaces, redisWorkspacePrefix)
+	if err != nil {
+		s.Log.Println("Error listing workspaces: ", err)
+		return nil, err
+	}
+
+	workspaces := make([]Workspace, 0, len(ids))
+	for i, id := range ids {
+		if name, createdAt, ok := parseRedisCreated(values[i]); ok {
+			workspaces = append(workspaces, Workspace{ID: id, Name: name, CreatedAt: createdAt})
+		}
+	}
+	sortWorkspaces(workspaces)
+	return workspaces, nil
+}
+
+// listCreated reads the name and created_at fields of every hash indexed in the sorted set
+func (s *RedisStore) listCreated(ctx context.Context, index string, prefix string) ([]string, [][]interface{}, error) {
+	ids, err := s.client.ZRange(ctx, index, 0, -1).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.SliceCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HMGet(ctx, prefix+id, "name", "created_at")
+	}
+	if len(ids) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	values := make([][]interface{}, len(ids))
+	for i := range ids {
+		values[i] = cmds[i].Val()
+	}
+	return ids, values, nil
+}
+
+// parseRedisCreated reads the name and created_at fields of a user or workspace hash
+func parseRedisCreated(values []interface{}) (string, time.Time, bool) {
+	name, ok := values[0].(string)
+	if !ok {
+		return "", time.Time{}, false
+	}
+	createdAt, _ := values[1].(string)
+	ms, _ := strconv.ParseInt(createdAt, 10, 64)
+	return name, time.UnixMilli(ms), true
+}
+
+func (s *RedisStore) SetMember(ctx context.Context, member Member) error {
+	set, err := redisSetMemberScript.Run(ctx, s.client,
+		[]string{redisWorkspacePrefix + member.WorkspaceID, redisUserPrefix + member.UserID, redisMembersPrefix + member.WorkspaceID},
+		member.UserID, member.Role, RoleOwner).Int()
+	if err != nil {
+		s.Log.Println("Error setting member: ", err)
+		return err
+	}
+
+	switch set {
+	case 0:
+		return ErrWorkspaceNotFound
+	case -1:
+		return ErrUserNotFound
+	case -2:
+		return ErrLastOwner
+	}
+	return nil
+}
+
+func (s *RedisStore) GetMember(ctx context.Context, workspaceID string, userID string) (Member, error) {
+	role, err := s.client.HGet(ctx, redisMembersPrefix+workspaceID, userID).Result()
+	if err == redis.Nil {
+		return Member{}, ErrMemberNotFound
+	}
+	if err != nil {
+		s.Log.Println("Error querying member: ", err)
+		return Member{}, err
+	}
+	return Member{WorkspaceID: workspaceID, UserID: userID, Role: role}, nil
+}
+
+func (s *RedisStore) ListMembers(ctx context.Context, workspaceID string) ([]Member, error) {
+	found, err := s.client.Exists(ctx, redisWorkspacePrefix+workspaceID).Result()
+	if err != nil {
+		s.Log.Println("Error listing members: ", err)
+		return nil, err
+	}
+	if found == 0 {
+		return nil, ErrWorkspaceNotFound
+	}
+
+	roles, err := s.client.HGetAll(ctx, redisMembersPrefix+workspaceID).Result()
+	if err != nil {
+		s.Log.Println("Error listing members: ", err)
+		return nil, err
+	}
+
+	members := make([]Member, 0, len(roles))
+	for userID, role := range roles {
+		members = append(members, Member{WorkspaceID: workspaceID, UserID: userID, Role: role})
+	}
+	sortMembers(members)
+	return members, nil
+}
+
+func (s *RedisStore) RemoveMember(ctx context.Context, workspaceID string, userID string) error {
+	removed, err := redisRemoveMemberScript.Run(ctx, s.client, []string{redisMembersPrefix + workspaceID}, userID, RoleOwner).Int()
+	if err != nil {
+		s.Log.Println("Error removing member: ", err)
+		return err
+	}
+
+	switch removed {
+	case 0:
+		return ErrMemberNotFound
+	case -2:
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// RedisCache is a read-through cache in front of another store.
+// Redis errors never fail a request, the cache is bypassed for a while and the store answers instead.
+type RedisCache struct {
+	Store
+	client  *redis.Client
+	ttl     time.Duration
+	backoff time.Duration
+	// bypassUntil is the unix nanoseconds until which redis is not queried after an error
+	bypassUntil atomic.Int64
+	Log         *log.Logger
+}
+
+func NewRedisCache(st Store, config *RedisConfig) *RedisCache {
+	l := log.New(log.Writer(), "REDISCACHE:", log.LstdFlags)
+	l.Print("Caching links in redis ", config.Addr)
+	c := &RedisCache{
+		Store:   st,
+		client:  NewRedisClient(config),
+		ttl:     config.TTL,
+		backoff: config.Backoff,
+		Log:     l,
+	}
+	// A cache that is down at startup is bypassed like any other redis error
+	if err := c.client.Ping(context.Background()).Err(); err != nil {
+		c.fail(err)
+	}
+	return c
+}
+
+func (c *RedisCache) available() bool {
+	return time.Now().UnixNano() >= c.bypassUntil.Load()
+}
+
+func (c *RedisCache) fail(err error) {
+	c.Log.Printf("Bypassing the cache for %s: %v", c.backoff, err)
+	c.bypassUntil.Store(time.Now().Add(c.backoff).UnixNano())
+}
+
+func (c *RedisCache) Get(ctx context.Context, shortKey string) (string, error) {
+	link, err := c.GetLink(ctx, shortKey)
+	if err != nil {
+		return "", err
+	}
+
+	if link.Expired(time.Now()) {
+		return "", ErrKeyExpired
+	}
+
+	return link.URL, nil
+}
+
+func (c *RedisCache) GetLink(ctx context.Context, shortKey string) (Link, error) {
+	if c.available() {
+		var link Link
+		cached, err := c.client.Get(ctx, redisCachePrefix+shortKey).Bytes()
+		if err == nil && json.Unmarshal(cached, &link) == nil {
+			return link, nil
+		}
+		if err != nil && err != redis.Nil && ctx.Err() == nil {
+			c.fail(err)
+		}
+	}
+
+	link, err := c.Store.GetLink(ctx, shortKey)
+	if err != nil {
+		return Link{}, err
+	}
+
+	// Expiring links leave the cache when they expire
+	now := time.Now()
+	ttl := c.ttl
+	if !link.ExpiresAt.IsZero() && link.ExpiresAt.Sub(now) < ttl {
+		ttl = link.ExpiresAt.Sub(now)
+	}
+	if ttl > 0 && c.available() {
+		cached, _ := json.Marshal(link)
+		if err := c.client.Set(ctx, redisCachePrefix+shortKey, cached, ttl).Err(); err != nil && ctx.Err() == nil {
+			c.fail(err)
+		}
+	}
+	return link, nil
+}
+
+func (c *RedisCache) Update(ctx context.Context, shortKey string, originalURL string) error {
+	if err := c.Store.Update(ctx, shortKey, originalURL); err != nil {
+		return err
+	}
+	c.invalidate(ctx, shortKey)
+	return nil
+}
+
+func (c *RedisCache) Delete(ctx context.Context, shortKey string) error {
+	if err := c.Store.Delete(ctx, shortKey); err != nil {
+		return err
+	}
+	c.invalidate(ctx, shortKey)
+	return nil
+}
+
+// invalidate drops the cached link, which is still served by other instances until the TTL if redis is down
+func (c *RedisCache) invalidate(ctx context.Context, shortKey string) {
+	if err := c.client.Del(ctx, redisCachePrefix+shortKey).Err(); err != nil {
+		c.fail(err)
+	}
+}
+
+func (c *RedisCache) DbClose(ctx context.Context) {
+	c.client.Close()
+	c.Store.DbClose(ctx)
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	generator "go-url-short/internal/shorten"
+	"testing"
+	"time"
+)
+
+// newTestRedis starts an in-process server speaking the redis protocol, closed along with the test
+func newTestRedis(t *testing.T) *RedisConfig {
+	m := miniredis.RunT(t)
+	return &RedisConfig{Addr: m.Addr(), Mode: RedisModeStore, TTL: time.Hour, Timeout: time.Second, Backoff: time.Second}
+}
+
+func TestRedisStoreConformance(t *testing.T) {
+	st, err := NewRedisStore(newTestRedis(t), newTestKeyGenerator(t, generator.StrategyRandom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.DbClose(context.Background())
+
+	if err := CheckConformance(context.Background(), st); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRedisCacheConformance(t *testing.T) {
+	config := newTestRedis(t)
+	config.Mode = RedisModeCache
+	st := NewRedisCache(NewInMemStore(nil, newTestKeyGenerator(t, generator.StrategyRandom)), config)
+	defer st.DbClose(context.Background())
+
+	if err := CheckConformance(context.Background(), st); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRedisStoreSeedsSequentialKeys(t *testing.T) {
+	ctx := context.Background()
+	config := newTestRedis(t)
+
+	st, err := NewRedisStore(config, newTestKeyGenerator(t, generator.StrategySequential))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := st.Set(ctx, "https://example.com/first", SetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Set(ctx, "https://example.com/alias", SetOptions{Alias: "zzzzzz"}); err != nil {
+		t.Fatal(err)
+	}
+	st.DbClose(ctx)
+
+	st, err = NewRedisStore(config, newTestKeyGenerator(t, generator.StrategySequential))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.DbClose(context.Background())
+	second, err := st.Set(ctx, "https://example.com/second", SetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstID, _ := generator.ConvertRadix10(first)
+	secondID, _ := generator.ConvertRadix10(second)
+	if secondID != firstID+1 {
+		t.Errorf("got key %s after %s, want the next sequential key", second, first)
+	}
+}
+
+func TestNewRedisStoreUnreachable(t *testing.T) {
+	config := newTestRedis(t)
+	config.Addr = "127.0.0.1:1"
+	if _, err := NewRedisStore(config, newTestKeyGenerator(t, generator.StrategyRandom)); err == nil {
+		t.Error("got no error connecting to a closed port")
+	}
+}
@@ -0,0 +1,693 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	generator "go-url-short/internal/shorten"
+	"log"
+	_ "modernc.org/sqlite"
+	"time"
+)
+
+type SQLiteConfig struct {
+	Path string `default:"" desc:"SQLite database file, used when no postgres host is configured"`
+}
+
+// sqliteMigrations are applied in order on open, PRAGMA user_version records how many were applied.
+// The tables mirror the postgres ones so keys and rows can be moved between both stores,
+// with times stored as unix milliseconds.
+var sqliteMigrations = []string{
+	`CREATE TABLE shorturl
+	(
+		id         INTEGER PRIMARY KEY,
+		alias      TEXT UNIQUE,
+		url        TEXT    NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER
+	);
+	CREATE INDEX shorturl_url_idx ON shorturl (url);
+	CREATE INDEX shorturl_expires_at_idx ON shorturl (expires_at) WHERE expires_at IS NOT NULL;`,
+	// Same dedup_hash as postgres, the links created before are never shared
+	`ALTER TABLE shorturl ADD COLUMN dedup_hash BLOB;
+	CREATE UNIQUE INDEX shorturl_dedup_hash_idx ON shorturl (dedup_hash);
+	DROP INDEX shorturl_url_idx;`,
+	`CREATE TABLE api_keys
+	(
+		id         TEXT PRIMARY KEY,
+		key_hash   BLOB    NOT NULL UNIQUE,
+		owner      TEXT    NOT NULL,
+		scopes     TEXT    NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		revoked_at INTEGER
+	);
+	ALTER TABLE shorturl ADD COLUMN owner TEXT NOT NULL DEFAULT '';`,
+	`CREATE TABLE users
+	(
+		id         TEXT PRIMARY KEY,
+		name       TEXT    NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE TABLE workspaces
+	(
+		id         TEXT PRIMARY KEY,
+		name       TEXT    NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE TABLE workspace_members
+	(
+		workspace_id TEXT NOT NULL,
+		user_id      TEXT NOT NULL,
+		role         TEXT NOT NULL,
+		PRIMARY KEY (workspace_id, user_id)
+	);
+	ALTER TABLE api_keys ADD COLUMN user_id TEXT NOT NULL DEFAULT '';
+	CREATE INDEX shorturl_owner_id_idx ON shorturl (owner, id);`,
+	// The clicks of analytics.SQLiteSink, a row stands for count identical clicks like in postgres
+	`CREATE TABLE clicks
+	(
+		id         INTEGER PRIMARY KEY,
+		short_key  TEXT    NOT NULL,
+		clicked_at INTEGER NOT NULL,
+		referrer   TEXT    NOT NULL DEFAULT '',
+		user_agent TEXT    NOT NULL DEFAULT '',
+		ip         TEXT    NOT NULL DEFAULT '',
+		count      INTEGER NOT NULL DEFAULT 1
+	);
+	CREATE INDEX clicks_short_key_clicked_at_idx ON clicks (short_key, clicked_at);`,
+}
+
+// SQLiteStore keeps the links in a single SQLite file using the same snowflake/base62 key scheme as PostgresStore
+type SQLiteStore struct {
+	db  *sql.DB
+	gen generator.KeyGenerator
+	// aliasIds gives the aliased rows ids that never collide with the generated ones
+	aliasIds generator.KeyGenerator
+	Log      *log.Logger
+}
+
+func NewSQLiteStore(config *SQLiteConfig, gen generator.KeyGenerator) *SQLiteStore {
+	l := log.New(log.Writer(), "SQLITESTORE:", log.LstdFlags)
+	l.Print("Opening sqlite store ", config.Path)
+	db, err := OpenSQLite(config, l)
+	if err != nil {
+		panic(err)
+	}
+
+	aliasIds, err := generator.NewSnowflakeGenerator()
+	if err != nil {
+		panic(err)
+	}
+
+	if seeder, ok := gen.(generator.Seeder); ok {
+		var last int64
+		err := db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM shorturl WHERE alias IS NULL").Scan(&last)
+		if err != nil {
+			panic(err)
+		}
+		seeder.Seed(last)
+	}
+
+	return &SQLiteStore{
+		Log:      l,
+		db:       db,
+		gen:      gen,
+		aliasIds: aliasIds,
+	}
+}
+
+// OpenSQLite opens the database file and applies the pending migrations,
+// the store and the analytics sink each open their own handle on the same file
+func OpenSQLite(config *SQLiteConfig, l *log.Logger) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", config.Path))
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has a single writer, sharing one connection avoids SQLITE_BUSY errors
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(db, l); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func migrateSQLite(db *sql.DB, l *log.Logger) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("sqlite migration %d: %w", version+1, err)
+		}
+		// PRAGMA does not take parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		l.Printf("Applied sqlite migration %d", version+1)
+	}
+	return nil
+}
+
+func (s SQLiteStore) DbClose(ctx context.Context) {
+	s.Log.Println("Closing database connection")
+	s.db.Close()
+}
+
+// sqliteKeyCondition matches a short key against custom aliases first and then against generated ids.
+// ?1 is the short key and ?2 its radix10 id.
+const sqliteKeyCondition = "(alias = ?1 OR (alias IS NULL AND id = ?2))"
+
+func (s SQLiteStore) Get(ctx context.Context, shortKey string) (string, error) {
+	link, err := s.GetLink(ctx, shortKey)
+	if err != nil {
+		return "", err
+	}
+
+	if link.Expired(time.Now()) {
+		return "", ErrKeyExpired
+	}
+
+	return link.URL, nil
+}
+
+func (s SQLiteStore) GetLink(ctx context.Context, shortKey string) (Link, error) {
+	// Keys that are not radix62 ids can still be aliases
+	k, _ := generator.ConvertRadix10(shortKey)
+
+	link := Link{Key: shortKey}
+	var createdAt int64
+	var expiresAt sql.NullInt64
+	row := s.db.QueryRowContext(ctx, `SELECT url, created_at, expires_at, owner FROM shorturl
+		WHERE `+sqliteKeyCondition+` ORDER BY alias IS NULL LIMIT 1`, shortKey, k)
+	if err := row.Scan(&link.URL, &createdAt, &expiresAt, &link.Owner); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return Link{}, ctxErr
+		}
+		if err != sql.ErrNoRows {
+			s.Log.Println("Error querying database: ", err, k)
+			return Link{}, err
+		}
+		return Link{}, ErrKeyNotFound
+	}
+
+	link.CreatedAt = time.UnixMilli(createdAt)
+	if expiresAt.Valid {
+		link.ExpiresAt = time.UnixMilli(expiresAt.Int64)
+	}
+	return link, nil
+}
+
+func (s SQLiteStore) Set(ctx context.Context, originalURL string, opts SetOptions) (string, error) {
+	if opts.Alias != "" {
+		return s.setAlias(ctx, originalURL, opts)
+	}
+
+	hash := opts.dedupHash(originalURL)
+	if hash != nil {
+		if key, err := s.dedupKey(ctx, hash); err != nil || key != "" {
+			return key, err
+		}
+	}
+
+	for attempt := 1; attempt <= maxSetAttempts; attempt++ {
+		newId, err := nextID(s.gen, originalURL, attempt)
+		if err != nil {
+			s.Log.Println("Error generating key: ", err)
+			return "", err
+		}
+
+		// The generated key must not be taken nor shadow a custom alias with the same name
+		var k int64
+		err = s.db.QueryRowContext(ctx, `INSERT INTO shorturl (id, url, created_at, expires_at, dedup_hash, owner) SELECT ?1, ?2, ?4, ?5, ?6, ?7
+			WHERE NOT EXISTS (SELECT 1 FROM shorturl WHERE alias = ?3)
+			ON CONFLICT DO NOTHING RETURNING id`,
+			newId, originalURL, generator.ConvertRadix62(newId), time.Now().UnixMilli(), nullMillis(opts.ExpiresAt), hash, opts.Owner).Scan(&k)
+		if err != nil && err == sql.ErrNoRows {
+			// The conflict may be the same url shared in the meantime rather than the key
+			if hash != nil {
+				if key, err := s.dedupKey(ctx, hash); err != nil || key != "" {
+					return key, err
+				}
+			}
+			s.Log.Printf("Generated key(%s) already exists, attempt %d", generator.ConvertRadix62(newId), attempt)
+			continue
+		}
+
+		if err != nil {
+			s.Log.Println("Error inserting into database: ", err)
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return "", ctxErr
+			}
+			return "", err
+		}
+
+		s.Log.Println("Inserted into database: ", k)
+		return generator.ConvertRadix62(k), nil
+	}
+
+	return "", ErrKeyGenerationFailed
+}
+
+func (s SQLiteStore) SetBatch(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	return setEach(ctx, s.Set, items)
+}
+
+// dedupKey returns the key of the link shared under the dedup hash, empty when there is none
+func (s SQLiteStore) dedupKey(ctx context.Context, hash []byte) (string, error) {
+	var k int64
+	err := s.db.QueryRowContext(ctx, "SELECT id FROM shorturl WHERE dedup_hash = ?1", hash).Scan(&k)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		s.Log.Println("Error checking if key exists: ", err)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		return "", err
+	}
+	return generator.ConvertRadix62(k), nil
+}
+
+// setAlias stores the original URL under a custom alias.
+// Aliased rows still get a snowflake id so they can be paged with the generated ones.
+func (s SQLiteStore) setAlias(ctx context.Context, originalURL string, opts SetOptions) (string, error) {
+	alias := opts.Alias
+	// Aliases too long to be a radix62 id can only collide with other aliases
+	k, _ := generator.ConvertRadix10(alias)
+
+	newId, err := s.aliasIds.NextID(originalURL)
+	if err != nil {
+		s.Log.Println("Error generating snowflake key: ", err)
+		return "", err
+	}
+
+	// Reject aliases already taken by another alias or by a generated key
+	var id int64
+	err = s.db.QueryRowContext(ctx, `INSERT INTO shorturl (id, alias, url, created_at, expires_at, owner) SELECT ?3, ?1, ?4, ?5, ?6, ?7
+		WHERE NOT EXISTS (SELECT 1 FROM shorturl WHERE `+sqliteKeyCondition+`)
+		ON CONFLICT (alias) DO NOTHING RETURNING id`,
+		alias, k, newId, originalURL, time.Now().UnixMilli(), nullMillis(opts.ExpiresAt), opts.Owner).Scan(&id)
+	if err != nil && err == sql.ErrNoRows {
+		return "", ErrKeyAlreadyExists
+	}
+
+	if err != nil {
+		s.Log.Println("Error inserting alias into database: ", err)
+		return "", err
+	}
+
+	s.Log.Println("Inserted alias into database: ", alias)
+	return alias, nil
+}
+
+func (s SQLiteStore) Update(ctx context.Context, shortKey string, originalURL string) error {
+	// Keys that are not radix62 ids can still be aliases
+	k, _ := generator.ConvertRadix10(shortKey)
+
+	// The link no longer points at the url it was shared for
+	res, err := s.db.ExecContext(ctx, "UPDATE shorturl SET url = ?3, dedup_hash = NULL WHERE "+sqliteKeyCondition, shortKey, k, originalURL)
+	if err != nil {
+		s.Log.Println("Error updating database: ", err, k)
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrKeyNotFound
+	}
+
+	s.Log.Println("Updated in database: ", shortKey)
+	return nil
+}
+
+func (s SQLiteStore) Delete(ctx context.Context, shortKey string) error {
+	// Keys that are not radix62 ids can still be aliases
+	k, _ := generator.ConvertRadix10(shortKey)
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM shorturl WHERE "+sqliteKeyCondition, shortKey, k)
+	if err != nil {
+		s.Log.Println("Error deleting from database: ", err, k)
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrKeyNotFound
+	}
+
+	s.Log.Println("Deleted from database: ", shortKey)
+	return nil
+}
+
+// List pages through the links ordered by id, the cursor is the radix62 id of the last link of the previous page
+func (s SQLiteStore) List(ctx context.Context, cursor string, limit int) ([]Link, string, error) {
+	return s.list(ctx, cursor, limit, "")
+}
+
+func (s SQLiteStore) ListByOwner(ctx context.Context, owner string, cursor string, limit int) ([]Link, string, error) {
+	return s.list(ctx, cursor, limit, "owner = ?3 AND", owner)
+}
+
+// list pages through the links matching the condition, whose arguments start at ?3
+func (s SQLiteStore) list(ctx context.Context, cursor string, limit int, condition string, args ...any) ([]Link, string, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	var after int64
+	if cursor != "" {
+		k, err := generator.ConvertRadix10(cursor)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		after = k
+	}
+
+	// Fetch one extra row to know whether there is a next page
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, alias, url, created_at, expires_at, owner FROM shorturl WHERE "+condition+" id > ?1 ORDER BY id LIMIT ?2",
+		append([]any{after, limit + 1}, args...)...)
+	if err != nil {
+		s.Log.Println("Error listing database: ", err)
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	links := make([]Link, 0, limit)
+	ids := make([]int64, 0, limit)
+	for rows.Next() {
+		var id, createdAt int64
+		var alias sql.NullString
+		var expiresAt sql.NullInt64
+		var link Link
+		if err := rows.Scan(&id, &alias, &link.URL, &createdAt, &expiresAt, &link.Owner); err != nil {
+			return nil, "", err
+		}
+		link.CreatedAt = time.UnixMilli(createdAt)
+		if expiresAt.Valid {
+			link.ExpiresAt = time.UnixMilli(expiresAt.Int64)
+		}
+		link.Key = generator.ConvertRadix62(id)
+		if alias.Valid {
+			link.Key = alias.String
+		}
+		links = append(links, link)
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(links) > limit {
+		links = links[:limit]
+		next = generator.ConvertRadix62(ids[limit-1])
+	}
+	return links, next, nil
+}
+
+func (s SQLiteStore) PurgeExpired(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM shorturl WHERE expires_at < ?1", before.UnixMilli())
+	if err != nil {
+		s.Log.Println("Error purging expired links: ", err)
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func (s SQLiteStore) CreateAPIKey(ctx context.Context, key APIKey) error {
+	res, err := s.db.ExecContext(ctx, `INSERT INTO api_keys (id, key_hash, owner, user_id, scopes, created_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6) ON CONFLICT DO NOTHING`,
+		key.ID, key.Hash, key.Owner, key.UserID, joinScopes(key.Scopes), key.CreatedAt.UnixMilli())
+	if err != nil {
+		s.Log.Println("Error inserting api key into database: ", err)
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrAPIKeyAlreadyExists
+	}
+
+	s.Log.Println("Inserted api key into database: ", key.ID)
+	return nil
+}
+
+func (s SQLiteStore) GetAPIKey(ctx context.Context, hash []byte) (APIKey, error) {
+	row := s.db.QueryRowContext(ctx,
+		"SELECT id, key_hash, owner, user_id, scopes, created_at, revoked_at FROM api_keys WHERE key_hash = ?1", hash)
+	key, err := scanSQLiteAPIKey(row.Scan)
+	if err == sql.ErrNoRows {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		s.Log.Println("Error querying api key: ", err)
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+func (s SQLiteStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, key_hash, owner, user_id, scopes, created_at, revoked_at FROM api_keys ORDER BY created_at, id")
+	if err != nil {
+		s.Log.Println("Error listing api keys: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanSQLiteAPIKey(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s SQLiteStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, ?2) WHERE id = ?1", id, at.UnixMilli())
+	if err != nil {
+		s.Log.Println("Error revoking api key: ", err)
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	s.Log.Println("Revoked api key: ", id)
+	return nil
+}
+
+// scanSQLiteAPIKey reads the id, key_hash, owner, user_id, scopes, created_at and revoked_at columns
+func scanSQLiteAPIKey(scan func(dest ...any) error) (APIKey, error) {
+	var key APIKey
+	var scopes string
+	var createdAt int64
+	var revokedAt sql.NullInt64
+	if err := scan(&key.ID, &key.Hash, &key.Owner, &key.UserID, &scopes, &createdAt, &revokedAt); err != nil {
+		return APIKey{}, err
+	}
+	key.Scopes = splitScopes(scopes)
+	key.CreatedAt = time.UnixMilli(createdAt)
+	if revokedAt.Valid {
+		key.RevokedAt = time.UnixMilli(revokedAt.Int64)
+	}
+	return key, nil
+}
+
+func (s SQLiteStore) CreateUser(ctx context.Context, user User) error {
+	res, err := s.db.ExecContext(ctx, "INSERT INTO users (id, name, created_at) VALUES (?1, ?2, ?3) ON CONFLICT DO NOTHING",
+		user.ID, user.Name, user.CreatedAt.UnixMilli())
+	if err != nil {
+		s.Log.Println("Error inserting user into database: ", err)
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+func (s SQLiteStore) ListUsers(ctx context.Context) ([]User, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, created_at FROM users ORDER BY created_at, id")
+	if err != nil {
+		s.Log.Println("Error listing users: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		var createdAt int64
+		if err := rows.Scan(&user.ID, &user.Name, &createdAt); err != nil {
+			return nil, err
+		}
+		user.CreatedAt = time.UnixMilli(createdAt)
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s SQLiteStore) CreateWorkspace(ctx context.Context, workspace Workspace, first Member) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO workspaces (id, name, created_at) VALUES (?1, ?2, ?3) ON CONFLICT DO NOTHING",
+		workspace.ID, workspace.Name, workspace.CreatedAt.UnixMilli())
+	if err != nil {
+		s.Log.Println("Error inserting workspace into database: ", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrAlreadyExists
+	}
+
+	res, err = tx.ExecContext(ctx, `INSERT INTO workspace_members (workspace_id, user_id, role)
+		SELECT ?1, ?2, ?3 WHERE EXISTS (SELECT 1 FROM users WHERE id = ?2)`,
+		workspace.ID, first.UserID, first.Role)
+	if err != nil {
+		s.Log.Println("Error inserting member into database: ", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (s SQLiteStore) ListWorkspaces(ctx context.Context) ([]Workspace, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, created_at FROM workspaces ORDER BY created_at, id")
+	if err != nil {
+		s.Log.Println("Error listing workspaces: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := []Workspace{}
+	for rows.Next() {
+		var workspace Workspace
+		var createdAt int64
+		if err := rows.Scan(&workspace.ID, &workspace.Name, &createdAt); err != nil {
+			return nil, err
+		}
+		workspace.CreatedAt = time.UnixMilli(createdAt)
+		workspaces = append(workspaces, workspace)
+	}
+	return workspaces, rows.Err()
+}
+
+// SetMember and RemoveMember check that the workspace has another owner in the statement writing the member
+func (s SQLiteStore) SetMember(ctx context.Context, member Member) error {
+	res, err := s.db.ExecContext(ctx, `INSERT INTO workspace_members (workspace_id, user_id, role)
+		SELECT ?1, ?2, ?3 WHERE EXISTS (SELECT 1 FROM workspaces WHERE id = ?1) AND EXISTS (SELECT 1 FROM users WHERE id = ?2)
+		ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = excluded.role
+		WHERE excluded.role = ?4 OR workspace_members.role <> ?4
+			OR EXISTS (SELECT 1 FROM workspace_members o WHERE o.workspace_id = ?1 AND o.user_id <> ?2 AND o.role = ?4)`,
+		member.WorkspaceID, member.UserID, member.Role, RoleOwner)
+	if err != nil {
+		s.Log.Println("Error setting member: ", err)
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		var found bool
+		if err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM workspaces WHERE id = ?1)", member.WorkspaceID).Scan(&found); err != nil {
+			return err
+		}
+		if !found {
+			return ErrWorkspaceNotFound
+		}
+		if _, err := s.GetMember(ctx, member.WorkspaceID, member.UserID); err == nil {
+			return ErrLastOwner
+		}
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s SQLiteStore) GetMember(ctx context.Context, workspaceID string, userID string) (Member, error) {
+	member := Member{WorkspaceID: workspaceID, UserID: userID}
+	err := s.db.QueryRowContext(ctx, "SELECT role FROM workspace_members WHERE workspace_id = ?1 AND user_id = ?2",
+		workspaceID, userID).Scan(&member.Role)
+	if err == sql.ErrNoRows {
+		return Member{}, ErrMemberNotFound
+	}
+	if err != nil {
+		s.Log.Println("Error querying member: ", err)
+		return Member{}, err
+	}
+	return member, nil
+}
+
+func (s SQLiteStore) ListMembers(ctx context.Context, workspaceID string) ([]Member, error) {
+	var found bool
+	if err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM workspaces WHERE id = ?1)", workspaceID).Scan(&found); err != nil {
+		s.Log.Println("Error listing members: ", err)
+		return nil, err
+	}
+	if !found {
+		return nil, ErrWorkspaceNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT user_id, role FROM workspace_members WHERE workspace_id = ?1 ORDER BY user_id", workspaceID)
+	if err != nil {
+		s.Log.Println("Error listing members: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []Member{}
+	for rows.Next() {
+		member := Member{WorkspaceID: workspaceID}
+		if err := rows.Scan(&member.UserID, &member.Role); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (s SQLiteStore) RemoveMember(ctx context.Context, workspaceID string, userID string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM workspace_members WHERE workspace_id = ?1 AND user_id = ?2
+		AND (role <> ?3 OR EXISTS (SELECT 1 FROM workspace_members o WHERE o.workspace_id = ?1 AND o.user_id <> ?2 AND o.role = ?3))`,
+		workspaceID, userID, RoleOwner)
+	if err != nil {
+		s.Log.Println("Error removing member: ", err)
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if _, err := s.GetMember(ctx, workspaceID, userID); err == nil {
+			return ErrLastOwner
+		}
+		return ErrMemberNotFound
+	}
+	return nil
+}
+
+// nullMillis maps the zero time to NULL and other times to unix milliseconds
+func nullMillis(t time.Time) sql.NullInt64 {
+	return sql.NullInt64{Int64: t.UnixMilli(), Valid: !t.IsZero()}
+}
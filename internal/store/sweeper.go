@@ -0,0 +1,44 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+type SweeperConfig struct {
+	Interval  time.Duration `default:"1h" desc:"How often expired links are purged, 0 disables the sweeper"`
+	Retention time.Duration `default:"168h" desc:"How long expired links keep answering 410 Gone before they are purged"`
+}
+
+// StartSweeper purges the links expired for longer than the retention on every interval
+// until the returned stop function is called.
+func StartSweeper(st Store, config *SweeperConfig) (stop func()) {
+	l := log.New(log.Writer(), "SWEEPER:", log.LstdFlags)
+	ctx, cancel := context.WithCancel(context.Background())
+	if config == nil || config.Interval <= 0 {
+		l.Println("Sweeper disabled")
+		return cancel
+	}
+
+	go func() {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				n, err := st.PurgeExpired(ctx, now.Add(-config.Retention))
+				if err != nil {
+					l.Println("Error purging expired links: ", err)
+					continue
+				}
+				if n > 0 {
+					l.Printf("Purged %d expired links", n)
+				}
+			}
+		}
+	}()
+	return cancel
+}
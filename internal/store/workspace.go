@@ -0,0 +1,55 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+type User struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+}
+
+// Workspace is a team owning links, the ID of the workspace is the Owner of its links
+type Workspace struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+}
+
+// RoleOwner is the role of the members managing the workspace, the stores never leave a workspace without one
+const RoleOwner = "owner"
+
+// Member gives a user a role in a workspace
+type Member struct {
+	WorkspaceID string
+	UserID      string
+	Role        string
+}
+
+func sortUsers(users []User) {
+	sort.Slice(users, func(i, j int) bool {
+		return createdBefore(users[i].CreatedAt, users[i].ID, users[j].CreatedAt, users[j].ID)
+	})
+}
+
+func sortWorkspaces(workspaces []Workspace) {
+	sort.Slice(workspaces, func(i, j int) bool {
+		return createdBefore(workspaces[i].CreatedAt, workspaces[i].ID, workspaces[j].CreatedAt, workspaces[j].ID)
+	})
+}
+
+func sortMembers(members []Member) {
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].UserID < members[j].UserID
+	})
+}
+
+// createdBefore orders by creation, then by ID for the ones created at the same time
+func createdBefore(a time.Time, aID string, b time.Time, bID string) bool {
+	if !a.Equal(b) {
+		return a.Before(b)
+	}
+	return aID < bID
+}
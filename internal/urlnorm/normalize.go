@@ -0,0 +1,141 @@
+package urlnorm
+
+import (
+	"fmt"
+	"golang.org/x/net/idna"
+	"net"
+	"net/url"
+	"strings"
+)
+
+type Config struct {
+	Schemes          []string `default:"http,https" desc:"Schemes allowed in shortened urls"`
+	MaxLength        int      `default:"1024" desc:"Maximum length of a shortened url once normalized"`
+	StripDefaultPort bool     `default:"true" desc:"Drop the port when it is the default one of the scheme"`
+	StripFragment    bool     `default:"false" desc:"Drop the #fragment of shortened urls"`
+}
+
+// Error codes of the rejected urls
+const (
+	CodeMissing          = "missing_url"
+	CodeTooLong          = "url_too_long"
+	CodeInvalid          = "invalid_url"
+	CodeRelative         = "relative_url"
+	CodeSchemeNotAllowed = "scheme_not_allowed"
+	CodeMissingHost      = "missing_host"
+	CodeInvalidHost      = "invalid_host"
+)
+
+// Error describes why a url was rejected
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(code string, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// hostProfile is the lookup profile of IDNA, without the STD3 rules which reject the underscores found in real hosts
+var hostProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false))
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+type Normalizer struct {
+	schemes          map[string]bool
+	maxLength        int
+	stripDefaultPort bool
+	stripFragment    bool
+}
+
+func NewNormalizer(config *Config) *Normalizer {
+	schemes := make(map[string]bool, len(config.Schemes))
+	for _, scheme := range config.Schemes {
+		schemes[strings.ToLower(strings.TrimSpace(scheme))] = true
+	}
+	return &Normalizer{
+		schemes:          schemes,
+		maxLength:        config.MaxLength,
+		stripDefaultPort: config.StripDefaultPort,
+		stripFragment:    config.StripFragment,
+	}
+}
+
+// Normalize validates an absolute url and returns it in its normalized form:
+// lowercase scheme and host, IDN hosts encoded to punycode and, when configured, without default port nor fragment.
+// The returned error is always an *Error.
+func (n *Normalizer) Normalize(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", newError(CodeMissing, "url is required")
+	}
+	if len(raw) > n.maxLength {
+		return "", newError(CodeTooLong, "url must be at most %d characters", n.maxLength)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", newError(CodeInvalid, "url cannot be parsed: %v", errorCause(err))
+	}
+	if !u.IsAbs() {
+		return "", newError(CodeRelative, "url must be absolute, with a scheme such as https://")
+	}
+	if !n.schemes[u.Scheme] {
+		return "", newError(CodeSchemeNotAllowed, "url scheme %s is not allowed", u.Scheme)
+	}
+	if u.Opaque != "" || u.Host == "" {
+		return "", newError(CodeMissingHost, "url must have a host")
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", newError(CodeInvalidHost, "url host %s is invalid: %v", u.Hostname(), err)
+	}
+	port := u.Port()
+	if n.stripDefaultPort && port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+
+	if n.stripFragment {
+		u.Fragment, u.RawFragment = "", ""
+	}
+
+	normalized := u.String()
+	if len(normalized) > n.maxLength {
+		return "", newError(CodeTooLong, "url must be at most %d characters", n.maxLength)
+	}
+	return normalized, nil
+}
+
+// normalizeHost lowercases the host and encodes internationalized domain names to punycode
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", fmt.Errorf("empty host")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return strings.ToLower(host), nil
+	}
+	return hostProfile.ToASCII(strings.ToLower(strings.TrimSuffix(host, ".")))
+}
+
+// errorCause drops the operation and the url repeated by *url.Error
+func errorCause(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return urlErr.Err
+	}
+	return err
+}
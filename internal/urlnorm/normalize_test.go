@@ -0,0 +1,102 @@
+package urlnorm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	n := NewNormalizer(&Config{Schemes: []string{"http", " HTTPS "}, MaxLength: 64, StripDefaultPort: true})
+
+	for _, c := range []struct {
+		raw  string
+		want string
+	}{
+		{"https://example.com/path?q=1", "https://example.com/path?q=1"},
+		{"  https://example.com  ", "https://example.com"},
+		{"HTTPS://Example.COM/Path", "https://example.com/Path"},
+		{"https://example.com.", "https://example.com"},
+		{"https://bücher.example/", "https://xn--bcher-kva.example/"},
+		{"https://BÜCHER.example/", "https://xn--bcher-kva.example/"},
+		{"https://my_host.example.com/", "https://my_host.example.com/"},
+		{"https://example.com:443/", "https://example.com/"},
+		{"http://example.com:80/", "http://example.com/"},
+		{"http://example.com:443/", "http://example.com:443/"},
+		{"https://example.com:8443/", "https://example.com:8443/"},
+		{"https://[2001:DB8::1]:443/", "https://[2001:db8::1]/"},
+		{"https://[2001:db8::1]:8443/", "https://[2001:db8::1]:8443/"},
+		{"https://192.0.2.1/", "https://192.0.2.1/"},
+		{"https://example.com/#section", "https://example.com/#section"},
+	} {
+		t.Run(c.raw, func(t *testing.T) {
+			got, err := n.Normalize(c.raw)
+			if err != nil {
+				t.Fatalf("Normalize(%q) returned %v", c.raw, err)
+			}
+			if got != c.want {
+				t.Errorf("Normalize(%q) = %q, want %q", c.raw, got, c.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeOptions(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		config Config
+		raw    string
+		want   string
+	}{
+		{"default port kept", Config{Schemes: []string{"https"}, MaxLength: 64}, "https://example.com:443/", "https://example.com:443/"},
+		{"fragment dropped", Config{Schemes: []string{"https"}, MaxLength: 64, StripFragment: true}, "https://example.com/a#b", "https://example.com/a"},
+		{"encoded fragment dropped", Config{Schemes: []string{"https"}, MaxLength: 64, StripFragment: true}, "https://example.com/a#b%20c", "https://example.com/a"},
+		{"other scheme", Config{Schemes: []string{"ftp"}, MaxLength: 64, StripDefaultPort: true}, "ftp://example.com:21/file", "ftp://example.com:21/file"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := NewNormalizer(&c.config).Normalize(c.raw)
+			if err != nil {
+				t.Fatalf("Normalize(%q) returned %v", c.raw, err)
+			}
+			if got != c.want {
+				t.Errorf("Normalize(%q) = %q, want %q", c.raw, got, c.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeRejects(t *testing.T) {
+	n := NewNormalizer(&Config{Schemes: []string{"http", "https"}, MaxLength: 64, StripDefaultPort: true})
+
+	for _, c := range []struct {
+		raw  string
+		code string
+	}{
+		{"", CodeMissing},
+		{"   ", CodeMissing},
+		{"https://example.com/" + strings.Repeat("a", 50), CodeTooLong},
+		// The escaped path is longer than the url as it was given
+		{"https://example.com/" + strings.Repeat("é", 20), CodeTooLong},
+		{"https://example.com/%zz", CodeInvalid},
+		{"https://exa mple.com/", CodeInvalid},
+		{"example.com/path", CodeRelative},
+		{"/path", CodeRelative},
+		{"ftp://example.com/", CodeSchemeNotAllowed},
+		{"javascript:alert(1)", CodeSchemeNotAllowed},
+		{"https:example.com", CodeMissingHost},
+		{"https:///path", CodeMissingHost},
+		{"https://:443/", CodeInvalidHost},
+		{"https://exa\u200dmple.com/", CodeInvalidHost},
+	} {
+		t.Run(c.raw, func(t *testing.T) {
+			got, err := n.Normalize(c.raw)
+			var urlErr *Error
+			if !errors.As(err, &urlErr) {
+				t.Fatalf("Normalize(%q) = %q, %v, want an *Error", c.raw, got, err)
+			}
+			if urlErr.Code != c.code {
+				t.Errorf("Normalize(%q) returned code %s (%v), want %s", c.raw, urlErr.Code, err, c.code)
+			}
+		})
+	}
+}